@@ -0,0 +1,56 @@
+// Command linkcheck checks that every link in Markdown and HTML files still
+// resolves, and exits non-zero if any are broken.
+//
+// Usage:
+//
+//	linkcheck [-concurrency n] FILE...
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	concurrentdownloads "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func main() {
+	concurrency := flag.Int("concurrency", 8, "number of links to check at once")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-concurrency n] FILE...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	urls := []string{}
+	for _, name := range flag.Args() {
+		content, err := os.ReadFile(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		urls = append(urls, concurrentdownloads.ExtractFileLinks(name, content)...)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	checker := &concurrentdownloads.LinkChecker{
+		Downloader: &concurrentdownloads.Downloader{Concurrency: *concurrency},
+	}
+	results := checker.Check(ctx, urls)
+	fmt.Println(concurrentdownloads.FormatLinkReport(results))
+
+	for _, result := range results {
+		if result.Broken() {
+			stop()
+			os.Exit(1)
+		}
+	}
+}
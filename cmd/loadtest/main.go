@@ -0,0 +1,57 @@
+// Command loadtest hits a set of URLs at a fixed rate or concurrency for a
+// while, and reports throughput, errors and latency percentiles.
+//
+// Usage:
+//
+//	loadtest [-rate n] [-concurrency n] [-duration d] [-json] URL...
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	concurrentdownloads "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func main() {
+	rate := flag.Float64("rate", 0, "requests per second, or 0 to go as fast as the concurrency allows")
+	concurrency := flag.Int("concurrency", 8, "number of requests in flight at once")
+	duration := flag.Duration("duration", 10*time.Second, "how long to run for")
+	asJSON := flag.Bool("json", false, "output the report as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-rate n] [-concurrency n] [-duration d] [-json] URL...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	test := &concurrentdownloads.LoadTest{
+		Downloader: &concurrentdownloads.Downloader{Concurrency: *concurrency},
+		Targets:    flag.Args(),
+		Rate:       *rate,
+		Duration:   *duration,
+	}
+	// Interrupting still reports on what was done so far
+	report, _ := test.Run(ctx)
+
+	if *asJSON {
+		data, err := report.JSON()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+		return
+	}
+	fmt.Println(concurrentdownloads.FormatLoadReport(report))
+}
@@ -0,0 +1,87 @@
+// Command store downloads URLs into a content-addressed store, and garbage
+// collects blobs which are no longer referenced.
+//
+// Usage:
+//
+//	store [-dir dir] [-concurrency n] get URL...
+//	store [-dir dir] rm URL...
+//	store [-dir dir] gc
+//
+// Don't run gc while another process is downloading into the same store.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	concurrentdownloads "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func main() {
+	dir := flag.String("dir", "store", "directory of the store")
+	concurrency := flag.Int("concurrency", 8, "number of downloads at once")
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [-dir dir] [-concurrency n] get URL...\n", os.Args[0])
+		fmt.Fprintf(out, "       %s [-dir dir] rm URL...\n", os.Args[0])
+		fmt.Fprintf(out, "       %s [-dir dir] gc\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	store, err := concurrentdownloads.OpenStore(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "get":
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		d := &concurrentdownloads.Downloader{
+			Concurrency: *concurrency,
+			ErrorPolicy: concurrentdownloads.CollectErrors,
+		}
+		digests, err := d.DownloadToStore(ctx, store, args)
+		for _, url := range args {
+			if digest, ok := digests[url]; ok {
+				fmt.Println(digest, url)
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			stop()
+			os.Exit(1)
+		}
+	case "rm":
+		for _, url := range args {
+			if err := store.Remove(url); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	case "gc":
+		removed, freed, err := store.GC()
+		for _, digest := range removed {
+			fmt.Println("removed", digest)
+		}
+		fmt.Printf("freed %d bytes\n", freed)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
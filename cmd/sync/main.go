@@ -0,0 +1,53 @@
+// Command sync mirrors a remote directory, listed by an autoindex page or a
+// JSON listing, into a local directory, downloading only what has changed.
+//
+// Usage:
+//
+//	sync [-delete] [-dry-run] [-concurrency n] INDEX_URL DIR
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	concurrentdownloads "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func main() {
+	del := flag.Bool("delete", false, "delete local files which are gone remotely")
+	dryRun := flag.Bool("dry-run", false, "report what would change without changing anything")
+	concurrency := flag.Int("concurrency", 8, "number of downloads at once")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-delete] [-dry-run] [-concurrency n] INDEX_URL DIR\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	syncer := &concurrentdownloads.Syncer{
+		Downloader: &concurrentdownloads.Downloader{
+			Concurrency: *concurrency,
+			ErrorPolicy: concurrentdownloads.CollectErrors,
+		},
+		Delete: *del,
+		DryRun: *dryRun,
+	}
+	report, err := syncer.Sync(ctx, flag.Arg(0), flag.Arg(1))
+	if report != nil {
+		fmt.Println(concurrentdownloads.FormatSyncReport(report))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		stop()
+		os.Exit(1)
+	}
+}
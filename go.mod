@@ -2,7 +2,10 @@ module github.com/shakefu/go-concurrency-experiments
 
 go 1.25.1
 
-require github.com/charmbracelet/lipgloss/v2 v2.0.0-beta1
+require (
+	github.com/charmbracelet/lipgloss/v2 v2.0.0-beta1
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/charmbracelet/colorprofile v0.3.0 // indirect
@@ -0,0 +1,198 @@
+package concurrentdownloads
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultArchiveManifest is the name of the manifest entry written at the end
+// of an archive.
+const DefaultArchiveManifest = "MANIFEST.json"
+
+// archiveEpoch is the default modification time of every entry. It's the
+// earliest time a zip file can hold.
+var archiveEpoch = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// ArchiveOptions configures an [ArchiveSink].
+type ArchiveOptions struct {
+	// Path maps a URL to the name of its entry. It defaults to the host
+	// followed by the path, with "index.html" for paths ending in a slash.
+	Path func(url string) (string, error)
+	// ModTime is the modification time of every entry, defaulting to the
+	// start of 1980 so archives of the same downloads are identical.
+	ModTime time.Time
+	// Manifest is the name of the manifest entry, defaulting to
+	// [DefaultArchiveManifest].
+	Manifest string
+}
+
+// ArchiveEntry is an entry in an archive's manifest.
+type ArchiveEntry struct {
+	Path   string `json:"path"`
+	URL    string `json:"url"`
+	Digest string `json:"digest"`
+	Size   int    `json:"size"`
+}
+
+// ArchiveSink streams downloads into a tar or zip archive as they arrive,
+// followed by a manifest entry listing the digest of each one when it's
+// closed. Use it with [Downloader.DownloadTo], which writes entries in a
+// deterministic order.
+type ArchiveSink struct {
+	options ArchiveOptions
+	add     func(name string, data []byte) error
+	close   func() error
+	names   map[string]bool
+	entries []ArchiveEntry
+}
+
+// NewTarSink returns a sink which writes a tar archive to w, gzip compressed
+// when compress is set. Closing the sink doesn't close w.
+func NewTarSink(w io.Writer, compress bool, options ArchiveOptions) *ArchiveSink {
+	s := newArchiveSink(options)
+
+	closers := []io.Closer{}
+	if compress {
+		gz := gzip.NewWriter(w)
+		// The header holds a time as well, which would differ from run to run
+		gz.ModTime = s.options.ModTime
+		w = gz
+		closers = append(closers, gz)
+	}
+	tw := tar.NewWriter(w)
+	closers = append([]io.Closer{tw}, closers...)
+
+	s.add = func(name string, data []byte) error {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     int64(len(data)),
+			Mode:     0o644,
+			ModTime:  s.options.ModTime,
+			Format:   tar.FormatPAX,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	}
+	s.close = func() error {
+		for _, c := range closers {
+			if err := c.Close(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s
+}
+
+// NewZipSink returns a sink which writes a zip archive to w. Closing the sink
+// doesn't close w.
+func NewZipSink(w io.Writer, options ArchiveOptions) *ArchiveSink {
+	s := newArchiveSink(options)
+	zw := zip.NewWriter(w)
+
+	s.add = func(name string, data []byte) error {
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: s.options.ModTime}
+		header.SetMode(0o644)
+		f, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+		return err
+	}
+	s.close = zw.Close
+	return s
+}
+
+func newArchiveSink(options ArchiveOptions) *ArchiveSink {
+	if options.Path == nil {
+		options.Path = archivePath
+	}
+	if options.ModTime.IsZero() {
+		options.ModTime = archiveEpoch
+	}
+	options.ModTime = options.ModTime.UTC()
+	options.Manifest = cmp.Or(options.Manifest, DefaultArchiveManifest)
+	return &ArchiveSink{options: options, names: map[string]bool{}}
+}
+
+// Write adds result to the archive.
+func (s *ArchiveSink) Write(result *Result) error {
+	name, err := s.options.Path(result.URL)
+	if err != nil {
+		return err
+	}
+	// Every leading slash goes, or "//etc/x" would still be absolute
+	name = path.Clean(strings.TrimLeft(name, "/"))
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("invalid archive path %q for %s", name, result.URL)
+	}
+	if s.names[name] || name == s.options.Manifest {
+		return fmt.Errorf("duplicate archive path %q for %s", name, result.URL)
+	}
+	s.names[name] = true
+
+	if err := s.add(name, result.Body); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(result.Body)
+	s.entries = append(s.entries, ArchiveEntry{
+		Path:   name,
+		URL:    result.URL,
+		Digest: "sha256:" + hex.EncodeToString(sum[:]),
+		Size:   len(result.Body),
+	})
+	return nil
+}
+
+// Entries returns the manifest entries written so far.
+func (s *ArchiveSink) Entries() []ArchiveEntry {
+	return s.entries
+}
+
+// Close writes the manifest entry and finishes the archive.
+func (s *ArchiveSink) Close() error {
+	manifest := bytes.Buffer{}
+	enc := json.NewEncoder(&manifest)
+	enc.SetIndent("", "  ")
+	entries := s.entries
+	if entries == nil {
+		entries = []ArchiveEntry{}
+	}
+	if err := enc.Encode(entries); err != nil {
+		return err
+	}
+	if err := s.add(s.options.Manifest, manifest.Bytes()); err != nil {
+		return err
+	}
+	return s.close()
+}
+
+// archivePath is the default entry name for a URL, its host and path.
+func archivePath(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	name := u.Host + "/" + strings.TrimPrefix(u.Path, "/")
+	if strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+	return name, nil
+}
@@ -0,0 +1,153 @@
+package concurrentdownloads_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestArchiveSink(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		// Finish in the reverse order
+		if r.URL.Path == "/a" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		w.Write([]byte("body of " + r.URL.Path))
+	}))
+	defer srv.Close()
+
+	items := []Item{{URL: srv.URL + "/a"}, {URL: srv.URL + "/dir/"}, {URL: srv.URL + "/b"}}
+	flatten := func(raw string) (string, error) {
+		return strings.TrimPrefix(raw, srv.URL+"/"), nil
+	}
+
+	readTar := func(t *testing.T, data []byte) ([]string, map[string]string) {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(gz)
+		names := []string{}
+		contents := map[string]string{}
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !header.ModTime.Equal(time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)) {
+				t.Errorf("unexpected mtime %v", header.ModTime)
+			}
+			body, _ := io.ReadAll(tr)
+			names = append(names, header.Name)
+			contents[header.Name] = string(body)
+		}
+		return names, contents
+	}
+
+	t.Run("it writes a deterministic tar", func(t *testing.T) {
+		archives := [][]byte{}
+		for range 2 {
+			buf := bytes.Buffer{}
+			sink := NewTarSink(&buf, true, ArchiveOptions{})
+			if err := (&Downloader{}).DownloadTo(t.Context(), sink, items); err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+			archives = append(archives, buf.Bytes())
+		}
+		if !bytes.Equal(archives[0], archives[1]) {
+			t.Error("expected identical archives")
+		}
+
+		host := strings.TrimPrefix(srv.URL, "http://")
+		names, contents := readTar(t, archives[0])
+		expected := []string{host + "/a", host + "/dir/index.html", host + "/b", DefaultArchiveManifest}
+		if strings.Join(names, " ") != strings.Join(expected, " ") {
+			t.Errorf("expected %v, got %v", expected, names)
+		}
+
+		manifest := []ArchiveEntry{}
+		if err := json.Unmarshal([]byte(contents[DefaultArchiveManifest]), &manifest); err != nil {
+			t.Fatal(err)
+		}
+		if len(manifest) != 3 || manifest[0].URL != srv.URL+"/a" || !strings.HasPrefix(manifest[0].Digest, "sha256:") || manifest[0].Size != len("body of /a") {
+			t.Errorf("unexpected manifest %+v", manifest)
+		}
+	})
+
+	t.Run("it writes a zip", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		sink := NewZipSink(&buf, ArchiveOptions{Path: flatten, Manifest: "digests.json"})
+		if err := (&Downloader{}).DownloadTo(t.Context(), sink, items); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		if strings.Join(names, " ") != "a dir b digests.json" {
+			t.Errorf("unexpected entries %v", names)
+		}
+	})
+
+	t.Run("it leaves out failed downloads", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		sink := NewZipSink(&buf, ArchiveOptions{Path: flatten})
+		d := &Downloader{ErrorPolicy: CollectErrors}
+		err := d.DownloadTo(t.Context(), sink, append([]Item{{URL: srv.URL + "/missing"}}, items...))
+		var status *StatusError
+		if !errors.As(err, &status) {
+			t.Errorf("expected a status error, got %v", err)
+		}
+		if len(sink.Entries()) != 3 {
+			t.Errorf("unexpected entries %v", sink.Entries())
+		}
+	})
+
+	t.Run("it rejects duplicate paths", func(t *testing.T) {
+		sink := NewZipSink(io.Discard, ArchiveOptions{Path: func(string) (string, error) { return "same", nil }})
+		err := (&Downloader{}).DownloadTo(t.Context(), sink, items)
+		if err == nil || !strings.Contains(err.Error(), "duplicate") {
+			t.Errorf("expected a duplicate path error, got %v", err)
+		}
+	})
+
+	t.Run("it keeps paths inside the archive", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		sink := NewTarSink(&buf, false, ArchiveOptions{Path: func(string) (string, error) { return "//etc/x", nil }})
+		if err := (&Downloader{}).DownloadTo(t.Context(), sink, items[:1]); err != nil {
+			t.Fatal(err)
+		}
+		if entries := sink.Entries(); len(entries) != 1 || entries[0].Path != "etc/x" {
+			t.Errorf("expected a relative path, got %v", entries)
+		}
+	})
+}
@@ -1,17 +1,24 @@
 package concurrentdownloads_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"strings"
 	"testing"
 
 	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+	"github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads/downloadtest"
 )
 
 func TestConcurrentDownloads(t *testing.T) {
+	page := downloadtest.Response{Body: "<html><title>Example Domain</title></html>"}
+
 	t.Run("it works", func(t *testing.T) {
-		urls := []string{
-			"https://example.com",
-		}
+		s := downloadtest.NewServer(t)
+		s.Handle("/", page)
+		urls := []string{s.URL("/")}
 
 		ctx := t.Context()
 		data, err := DownloadAll(ctx, urls)
@@ -24,7 +31,7 @@ func TestConcurrentDownloads(t *testing.T) {
 			t.Errorf("data is the wrong length: %v != %v", len(data), len(urls))
 		}
 
-		body := data["https://example.com"]
+		body := data[s.URL("/")]
 		if !strings.Contains(body, "<title>Example Domain</title>") {
 			t.Log(body)
 			t.Errorf("data content did not match expected page content")
@@ -32,9 +39,12 @@ func TestConcurrentDownloads(t *testing.T) {
 	})
 
 	t.Run("it downloads multiple urls", func(t *testing.T) {
+		s := downloadtest.NewServer(t)
+		s.Handle("/", page)
+		s.Handle("/200", downloadtest.Response{Body: "200 OK"})
 		urls := []string{
-			"https://example.com",
-			"https://httpstatuses.maor.io/200",
+			s.URL("/"),
+			s.URL("/200"),
 		}
 		ctx := t.Context()
 		data, err := DownloadAll(ctx, urls)
@@ -56,29 +66,111 @@ func TestConcurrentDownloads(t *testing.T) {
 	})
 
 	t.Run("it fails if a url fails", func(t *testing.T) {
-		urls := []string{
-			"https://httpstatuses.maor.io/500",
-			"https://httpstatuses.maor.io/200",
-			"https://httpstatuses.maor.io/204",
-			"https://httpstatuses.maor.io/400",
-			"https://httpstatuses.maor.io/401",
-			"https://httpstatuses.maor.io/403",
-			"https://httpstatuses.maor.io/404",
+		s := downloadtest.NewServer(t)
+		urls := []string{}
+		for _, status := range []int{500, 200, 204, 400, 401, 403, 404} {
+			path := fmt.Sprintf("/%d", status)
+			s.Handle(path, downloadtest.Response{Status: status})
+			urls = append(urls, s.URL(path))
 		}
 		ctx := t.Context()
 		data, err := DownloadAll(ctx, urls)
 
 		if err == nil {
-			t.Error("expected error, got none")
+			t.Fatal("expected error, got none")
 		}
 
 		if !strings.HasPrefix(err.Error(), "error fetching URL") {
 			t.Error("expected error to start with 'error fetching URL'")
 		}
 
-		// Only one URL can possibly succeed
+		// Only two URLs can possibly succeed
 		if len(data) > 2 {
 			t.Errorf("data is the wrong length: %v > %v", len(data), 2)
 		}
 	})
+
+	t.Run("it retries until a url succeeds", func(t *testing.T) {
+		s := downloadtest.NewServer(t)
+		s.Handle("/flaky",
+			downloadtest.Response{Status: http.StatusServiceUnavailable},
+			downloadtest.Response{Body: "partial", Truncate: 3},
+			downloadtest.Response{Body: "ok"},
+		)
+
+		d := &Downloader{Retries: 2}
+		data, err := d.FetchURL(t.Context(), s.URL("/flaky"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "ok" {
+			t.Errorf("unexpected body %q", data)
+		}
+		s.AssertRequests(t, "/flaky", 3)
+	})
+
+	t.Run("it gives up after its retries", func(t *testing.T) {
+		s := downloadtest.NewServer(t)
+		s.Handle("/reset", downloadtest.Response{Reset: true})
+
+		d := &Downloader{Retries: 2}
+		if _, err := d.FetchURL(t.Context(), s.URL("/reset")); err == nil {
+			t.Error("expected an error")
+		}
+		s.AssertRequests(t, "/reset", 3)
+	})
+
+	t.Run("it doesn't retry client errors", func(t *testing.T) {
+		s := downloadtest.NewServer(t)
+		s.Handle("/missing", downloadtest.Response{Status: http.StatusNotFound})
+
+		d := &Downloader{Retries: 2}
+		if _, err := d.FetchURL(t.Context(), s.URL("/missing")); err == nil {
+			t.Error("expected an error")
+		}
+		s.AssertRequests(t, "/missing", 1)
+	})
+
+	t.Run("it limits concurrency", func(t *testing.T) {
+		s := downloadtest.NewServer(t)
+		gate := make(chan struct{})
+		s.Handle("/held", downloadtest.Response{Gate: gate, Body: "ok"})
+		urls := make([]string, 8)
+		for i := range urls {
+			urls[i] = s.URL("/held?" + string(rune('a'+i)))
+		}
+
+		done := make(chan error)
+		go func() {
+			_, err := (&Downloader{Concurrency: 3}).DownloadAll(t.Context(), urls)
+			done <- err
+		}()
+		s.AwaitInFlight(t, 3)
+		close(gate)
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		s.AssertPeak(t, 3)
+		s.AssertRequests(t, "/held", 8)
+	})
+
+	t.Run("it stops when canceled", func(t *testing.T) {
+		s := downloadtest.NewServer(t)
+		s.Handle("/held", downloadtest.Response{Gate: make(chan struct{})})
+
+		ctx, cancel := context.WithCancel(t.Context())
+		done := make(chan error)
+		go func() {
+			_, err := (&Downloader{Concurrency: 2}).DownloadAll(ctx, []string{
+				s.URL("/held?a"), s.URL("/held?b"), s.URL("/held?c"),
+			})
+			done <- err
+		}()
+		s.AwaitInFlight(t, 2)
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("expected a cancellation, got %v", err)
+		}
+		s.AssertRequests(t, "/held", 2)
+	})
 }
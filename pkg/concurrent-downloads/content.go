@@ -0,0 +1,147 @@
+package concurrentdownloads
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// sniffLen is how much of a body http.DetectContentType looks at.
+const sniffLen = 512
+
+// ContentTypes are rules about what a download is allowed to be, checked
+// against both its Content-Type header and the type sniffed from the start of
+// its body with [http.DetectContentType]. Patterns are media types like
+// "application/pdf", or wildcards like "image/*" and "*/*".
+//
+// The body can't be sniffed when a download resumes part way through, or
+// when it's still compressed, so only the header is checked then.
+type ContentTypes struct {
+	// Allow is optional, and accepts a download when either type matches.
+	Allow []string
+	// Deny rejects a download when either type matches, so an HTML error
+	// page is caught even when its header claims it's something else.
+	Deny []string
+	// Match rejects a download whose header disagrees with its content, like
+	// a PDF which is really an HTML page. Sniffing can't tell JSON from plain
+	// text, or one binary format from another, so only the broad kind is
+	// compared: HTML, other text, or binary.
+	Match bool
+}
+
+// ContentTypeViolation is the rule a download broke.
+type ContentTypeViolation string
+
+const (
+	// ContentTypeNotAllowed means neither type was in the Allow list.
+	ContentTypeNotAllowed ContentTypeViolation = "not allowed"
+	// ContentTypeDenied means one of the types was in the Deny list.
+	ContentTypeDenied ContentTypeViolation = "denied"
+	// ContentTypeMismatch means the header disagreed with the content.
+	ContentTypeMismatch ContentTypeViolation = "mismatch"
+)
+
+// ContentTypeError is returned when a download breaks the Downloader's
+// ContentTypes rules.
+type ContentTypeError struct {
+	URL string
+	// Declared is the media type from the Content-Type header, if it had one.
+	Declared string
+	// Sniffed is the media type sniffed from the body, if it could be.
+	Sniffed   string
+	Violation ContentTypeViolation
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("content type %s for %s, declared: %q, sniffed: %q", e.Violation, e.URL, e.Declared, e.Sniffed)
+}
+
+// check checks a response against the rules, sniffing start when it's the
+// beginning of the body. A nil *ContentTypes allows everything.
+func (c *ContentTypes) check(url string, res *http.Response, start []byte) error {
+	if c == nil {
+		return nil
+	}
+
+	declared := ""
+	if header := res.Header.Get("Content-Type"); header != "" {
+		declared, _, _ = mime.ParseMediaType(header)
+		if declared == "" {
+			declared, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(header)), ";")
+		}
+	}
+	sniffed := ""
+	// The client only decodes the body when it asked for the encoding itself
+	encoding := res.Header.Get("Content-Encoding")
+	if len(start) > 0 && (res.Uncompressed || encoding == "" || encoding == "identity") {
+		sniffed, _, _ = mime.ParseMediaType(http.DetectContentType(start))
+	}
+	err := &ContentTypeError{URL: url, Declared: declared, Sniffed: sniffed}
+
+	if c.Deny != nil && (matchContentType(c.Deny, declared) || matchContentType(c.Deny, sniffed)) {
+		err.Violation = ContentTypeDenied
+		return err
+	}
+	if c.Allow != nil && !matchContentType(c.Allow, declared) && !matchContentType(c.Allow, sniffed) {
+		err.Violation = ContentTypeNotAllowed
+		return err
+	}
+	// A generic header doesn't claim anything to disagree with
+	if c.Match && declared != "" && declared != "application/octet-stream" && sniffed != "" &&
+		contentKind(declared) != contentKind(sniffed) {
+		err.Violation = ContentTypeMismatch
+		return err
+	}
+	return nil
+}
+
+// matchContentType reports whether mediaType matches any of the patterns.
+func matchContentType(patterns []string, mediaType string) bool {
+	if mediaType == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "*" {
+			return true
+		}
+		// Media types can't contain any of the other glob characters
+		if ok, _ := path.Match(pattern, mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// contentKind returns the broad kind of a media type, which is all sniffing
+// can reliably tell apart.
+func contentKind(mediaType string) string {
+	switch {
+	case mediaType == "text/html", mediaType == "application/xhtml+xml":
+		return "html"
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return "text"
+	}
+	switch strings.TrimPrefix(mediaType, "application/") {
+	case "json", "xml", "javascript", "ecmascript", "x-javascript", "x-ndjson",
+		"yaml", "x-yaml", "toml", "x-www-form-urlencoded":
+		return "text"
+	}
+	return "binary"
+}
+
+// peek returns the start of r for sniffing, along with a reader which still
+// reads all of it.
+func peek(r io.Reader) ([]byte, io.Reader, error) {
+	br := bufio.NewReaderSize(r, sniffLen)
+	start, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	return start, br, nil
+}
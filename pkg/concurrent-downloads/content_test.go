@@ -0,0 +1,121 @@
+package concurrentdownloads_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestContentTypes(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n" + "\x00\x00\x00\rIHDR"
+	html := "<!DOCTYPE html><html><body>Something went wrong</body></html>"
+	serve := func(contentType, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte(body))
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image.png", serve("image/png", png))
+	mux.HandleFunc("/error.png", serve("image/png", html))
+	mux.HandleFunc("/page.html", serve("text/html; charset=utf-8", html))
+	mux.HandleFunc("/data.json", serve("application/json", `{"ok": true}`))
+	mux.HandleFunc("/unlabeled", serve("application/octet-stream", html))
+	mux.HandleFunc("/site/", serve("text/html", `<a href="/image.png">image</a>`))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		rules     ContentTypes
+		path      string
+		violation ContentTypeViolation
+	}{
+		{"it allows matching types", ContentTypes{Allow: []string{"image/*"}}, "/image.png", ""},
+		{"it rejects types which aren't allowed", ContentTypes{Allow: []string{"image/*"}}, "/page.html", ContentTypeNotAllowed},
+		{"it denies the declared type", ContentTypes{Deny: []string{"text/html"}}, "/page.html", ContentTypeDenied},
+		{"it denies the sniffed type", ContentTypes{Deny: []string{"text/html"}}, "/error.png", ContentTypeDenied},
+		{"it accepts content which matches its header", ContentTypes{Match: true}, "/image.png", ""},
+		{"it accepts JSON sniffed as text", ContentTypes{Match: true}, "/data.json", ""},
+		{"it rejects content which doesn't match its header", ContentTypes{Match: true}, "/error.png", ContentTypeMismatch},
+		{"it doesn't compare generic headers", ContentTypes{Match: true}, "/unlabeled", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := &Downloader{ContentTypes: &test.rules, Retries: 2}
+			_, err := d.Fetch(t.Context(), srv.URL+test.path)
+			if test.violation == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			contentType := &ContentTypeError{}
+			if !errors.As(err, &contentType) {
+				t.Fatalf("expected a content type error, got %v", err)
+			}
+			if contentType.Violation != test.violation {
+				t.Errorf("expected %q, got %q", test.violation, contentType.Violation)
+			}
+		})
+	}
+
+	t.Run("it reports both types", func(t *testing.T) {
+		d := &Downloader{ContentTypes: &ContentTypes{Match: true}}
+		_, err := d.Fetch(t.Context(), srv.URL+"/error.png")
+		contentType := &ContentTypeError{}
+		if !errors.As(err, &contentType) || contentType.Declared != "image/png" || contentType.Sniffed != "text/html" {
+			t.Errorf("unexpected error %#v", err)
+		}
+	})
+
+	t.Run("it checks streamed downloads", func(t *testing.T) {
+		s, err := OpenStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := &Downloader{ContentTypes: &ContentTypes{Match: true}, ErrorPolicy: CollectErrors}
+		digests, err := d.DownloadToStore(t.Context(), s, []string{srv.URL + "/image.png", srv.URL + "/error.png"})
+		contentType := &ContentTypeError{}
+		if !errors.As(err, &contentType) || contentType.URL != srv.URL+"/error.png" {
+			t.Errorf("expected a content type error for error.png, got %v", err)
+		}
+		if len(digests) != 1 {
+			t.Errorf("expected the image to be stored, got %v", digests)
+		}
+		if _, ok := s.Lookup(srv.URL + "/image.png"); !ok {
+			t.Error("expected the image in the store")
+		}
+	})
+
+	t.Run("it leaves rejected pages out of a crawl", func(t *testing.T) {
+		d := &Downloader{ContentTypes: &ContentTypes{Deny: []string{"text/html"}}}
+		data, err := (&Crawler{Downloader: d, MaxDepth: 1}).Crawl(t.Context(), []string{srv.URL + "/site/"})
+		contentType := &ContentTypeError{}
+		if !errors.As(err, &contentType) || contentType.URL != srv.URL+"/site/" {
+			t.Errorf("expected a content type error for the page, got %v", err)
+		}
+		// The page's links are still followed
+		if _, ok := data[srv.URL+"/image.png"]; len(data) != 1 || !ok {
+			t.Errorf("expected only the image, got %v", data)
+		}
+	})
+
+	t.Run("it doesn't check what's read along the way", func(t *testing.T) {
+		d := &Downloader{ContentTypes: &ContentTypes{Deny: []string{"text/html"}}}
+		// The autoindex listing is HTML, the file isn't
+		remote := t.TempDir()
+		os.WriteFile(filepath.Join(remote, "image.png"), []byte(png), 0o644)
+		files := httptest.NewServer(http.FileServer(http.Dir(remote)))
+		defer files.Close()
+		report, err := (&Syncer{Downloader: d}).Sync(t.Context(), files.URL, t.TempDir())
+		if err != nil || len(report.Changes) != 1 {
+			t.Errorf("expected to sync the image, got %+v, %v", report, err)
+		}
+	})
+}
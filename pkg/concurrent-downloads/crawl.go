@@ -0,0 +1,264 @@
+package concurrentdownloads
+
+import (
+	"context"
+	"errors"
+	"html"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultUserAgent is sent by the crawler, and used to match robots.txt rules.
+const DefaultUserAgent = "concurrentdownloads"
+
+// Crawler crawls pages starting from seed URLs, following the links found in
+// HTML responses up to MaxDepth.
+type Crawler struct {
+	// Downloader provides the worker pool and its concurrency limit.
+	Downloader *Downloader
+	// MaxDepth is how many links away from a seed to follow, zero only
+	// downloads the seeds.
+	MaxDepth int
+	// Allow lists the hosts the crawl may visit. A leading dot allows all
+	// subdomains, e.g. ".example.com". When empty, the crawl stays on the
+	// origins of the seed URLs.
+	Allow []string
+	// Delay is the minimum time between requests to the same host.
+	Delay time.Duration
+	// IgnoreRobots disables robots.txt checks.
+	IgnoreRobots bool
+	// UserAgent defaults to [DefaultUserAgent].
+	UserAgent string
+}
+
+// crawl holds the state of a single Crawl call.
+type crawl struct {
+	*Crawler
+	robots  *robotsCache
+	origins map[string]bool
+
+	mu    sync.Mutex
+	depth map[string]int
+	hosts map[string]time.Time
+	data  map[string]string
+	errs  []error
+}
+
+// Crawl returns a map of {url:data} for every page reached from seeds, keyed by
+// the URL without its fragment or default port. Pages which fail to download
+// don't stop the crawl, their errors are joined and returned with the data.
+// Pages the Downloader's ContentTypes reject are left out of the data with an
+// error too, but their links are still followed.
+func (c *Crawler) Crawl(ctx context.Context, seeds []string) (map[string]string, error) {
+	d := c.Downloader
+	if d == nil {
+		d = &Downloader{}
+	}
+	userAgent := c.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+
+	cr := &crawl{
+		Crawler: c,
+		robots:  &robotsCache{userAgent: userAgent, downloader: d},
+		origins: map[string]bool{},
+		depth:   map[string]int{},
+		hosts:   map[string]time.Time{},
+		data:    map[string]string{},
+	}
+
+	for _, seed := range seeds {
+		u, err := url.Parse(seed)
+		if err != nil {
+			return nil, err
+		}
+		cr.origins[origin(u)] = true
+	}
+	items := []Item{}
+	for _, seed := range seeds {
+		if item, ok := cr.visit(seed, 0); ok {
+			items = append(items, item)
+		}
+	}
+
+	header := http.Header{"User-Agent": {userAgent}}
+	// Pages robots.txt disallows, or which redirect off the crawl, count as
+	// skipped
+	batch := d.Hooks.startBatch(d, len(items))
+	d.walk(ctx, items, func(item Item, push func(Item)) {
+		u, _ := url.Parse(item.URL)
+		if !c.IgnoreRobots && !cr.robots.allowed(ctx, u) {
+			return
+		}
+		if err := cr.wait(ctx, u.Host); err != nil {
+			return
+		}
+
+		start := time.Now()
+		result, err := d.fetch(ctx, item.URL, header)
+		if err != nil {
+			batch.item(item.URL, start, err)
+			if ctx.Err() == nil {
+				cr.fail(err)
+			}
+			return
+		}
+		// A redirect can lead off the crawl, so it's where the page ended up
+		// which has to be in scope
+		if !cr.inScope(result.Response.Request.URL) {
+			return
+		}
+		// A page the rules reject is left out, but still read for its links
+		rejected := d.ContentTypes.check(item.URL, result.Response, result.Body)
+		batch.item(item.URL, start, rejected)
+		if rejected != nil {
+			d.Metrics.error(rejected)
+			cr.fail(rejected)
+		}
+
+		cr.mu.Lock()
+		if rejected == nil {
+			cr.data[item.URL] = string(result.Body)
+		}
+		depth := cr.depth[item.URL]
+		cr.mu.Unlock()
+
+		if depth >= c.MaxDepth || !isHTML(result.Response.Header) {
+			return
+		}
+		for _, link := range extractLinks(result.Response.Request.URL, result.Body) {
+			if item, ok := cr.visit(link, depth+1); ok {
+				batch.add(1)
+				push(item)
+			}
+		}
+	})
+
+	cr.mu.Lock()
+	err := context.Cause(ctx)
+	if err == nil {
+		err = errors.Join(cr.errs...)
+	}
+	cr.mu.Unlock()
+	batch.finish(ctx, err)
+	return cr.data, err
+}
+
+// visit returns the item to queue for link, if it's in scope and hasn't been
+// seen before.
+func (cr *crawl) visit(link string, depth int) (Item, bool) {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return Item{}, false
+	}
+	link = normalizeURL(u)
+	if !cr.inScope(u) {
+		return Item{}, false
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if _, ok := cr.depth[link]; ok {
+		return Item{}, false
+	}
+	cr.depth[link] = depth
+	// Shallower pages first, so a crawl is breadth first when it's limited
+	return Item{URL: link, Priority: -depth}, true
+}
+
+func (cr *crawl) fail(err error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.errs = append(cr.errs, err)
+}
+
+// wait blocks until the politeness delay for host has passed, reserving the
+// next slot for this request.
+func (cr *crawl) wait(ctx context.Context, host string) error {
+	if cr.Delay <= 0 {
+		return nil
+	}
+
+	cr.mu.Lock()
+	now := time.Now()
+	next := cr.hosts[host]
+	if next.Before(now) {
+		next = now
+	}
+	cr.hosts[host] = next.Add(cr.Delay)
+	cr.mu.Unlock()
+
+	timer := time.NewTimer(next.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (cr *crawl) inScope(u *url.URL) bool {
+	if len(cr.Allow) == 0 {
+		return cr.origins[origin(u)]
+	}
+	host := u.Hostname()
+	for _, allow := range cr.Allow {
+		if host == allow || (strings.HasPrefix(allow, ".") && strings.HasSuffix(host, allow)) {
+			return true
+		}
+	}
+	return false
+}
+
+// origin returns the scheme and host of u.
+func origin(u *url.URL) string {
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host)
+}
+
+// normalizeURL returns u in a canonical form for deduplication, dropping the
+// fragment and default port, and lower casing the scheme and host.
+func normalizeURL(u *url.URL) string {
+	n := *u
+	n.Scheme = strings.ToLower(n.Scheme)
+	n.Host = strings.ToLower(n.Host)
+	n.Fragment = ""
+	n.RawFragment = ""
+	if port := n.Port(); (n.Scheme == "http" && port == "80") || (n.Scheme == "https" && port == "443") {
+		n.Host = n.Hostname()
+	}
+	if n.Path == "" {
+		n.Path = "/"
+	}
+	return n.String()
+}
+
+func isHTML(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// linkPattern matches href and src attributes, capturing the quoted or bare
+// value.
+var linkPattern = regexp.MustCompile(`(?i)\s(?:href|src)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+
+// extractLinks returns the absolute URLs of the links in an HTML document,
+// resolved against base.
+func extractLinks(base *url.URL, body []byte) []string {
+	links := []string{}
+	for _, match := range linkPattern.FindAllSubmatch(body, -1) {
+		raw := string(match[1]) + string(match[2]) + string(match[3])
+		ref, err := url.Parse(strings.TrimSpace(html.UnescapeString(raw)))
+		if err != nil {
+			continue
+		}
+		links = append(links, base.ResolveReference(ref).String())
+	}
+	return links
+}
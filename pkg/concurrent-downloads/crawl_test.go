@@ -0,0 +1,167 @@
+package concurrentdownloads_test
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestCrawler(t *testing.T) {
+	site := func(t *testing.T) *httptest.Server {
+		pages := map[string]string{
+			"/":                `<a href="/about">About</a> <a href='docs/'>Docs</a> <a href="https://elsewhere.invalid/">Out</a>`,
+			"/about":           `<a href="/">Home</a> <a href="/about#team">Team</a> <a href="/private/keys">Keys</a>`,
+			"/docs/":           `<a href="intro.html">Intro</a> <img src="/logo.png">`,
+			"/docs/intro.html": `<a href="/docs/deep">Deep</a>`,
+			"/docs/deep":       `deep`,
+			"/private/keys":    `secret`,
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private/\n")
+		})
+		mux.HandleFunc("/logo.png", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(`<a href="/never">`))
+		})
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			page, ok := pages[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, page)
+		})
+		srv := httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+		return srv
+	}
+
+	crawled := func(srv *httptest.Server, data map[string]string) []string {
+		paths := []string{}
+		for url := range maps.Keys(data) {
+			paths = append(paths, url[len(srv.URL):])
+		}
+		slices.Sort(paths)
+		return paths
+	}
+
+	t.Run("it crawls the site", func(t *testing.T) {
+		srv := site(t)
+		c := &Crawler{MaxDepth: 5, Downloader: &Downloader{Concurrency: 2}}
+		data, err := c.Crawl(t.Context(), []string{srv.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{"/", "/about", "/docs/", "/docs/deep", "/docs/intro.html", "/logo.png"}
+		if actual := crawled(srv, data); !slices.Equal(actual, expected) {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	})
+
+	t.Run("it stops at max depth", func(t *testing.T) {
+		srv := site(t)
+		c := &Crawler{MaxDepth: 1}
+		data, err := c.Crawl(t.Context(), []string{srv.URL + "/"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{"/", "/about", "/docs/"}
+		if actual := crawled(srv, data); !slices.Equal(actual, expected) {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	})
+
+	t.Run("it can ignore robots.txt", func(t *testing.T) {
+		srv := site(t)
+		c := &Crawler{MaxDepth: 1, IgnoreRobots: true}
+		data, err := c.Crawl(t.Context(), []string{srv.URL + "/about"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := data[srv.URL+"/private/keys"]; !ok {
+			t.Errorf("expected /private/keys to be crawled, got %v", crawled(srv, data))
+		}
+	})
+
+	t.Run("it only follows allowed hosts", func(t *testing.T) {
+		srv := site(t)
+		c := &Crawler{MaxDepth: 5, Allow: []string{"example.invalid"}}
+		data, err := c.Crawl(t.Context(), []string{srv.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 0 {
+			t.Errorf("expected nothing to be crawled, got %v", crawled(srv, data))
+		}
+	})
+
+	t.Run("it doesn't follow redirects off the crawl", func(t *testing.T) {
+		other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/secret">Secret</a>`)
+		}))
+		defer other.Close()
+		mux := http.NewServeMux()
+		mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, other.URL+"/", http.StatusFound)
+		})
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/moved">Moved</a>`)
+		})
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+
+		c := &Crawler{MaxDepth: 5, IgnoreRobots: true}
+		data, err := c.Crawl(t.Context(), []string{srv.URL + "/"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := []string{"/"}, crawled(srv, data); !slices.Equal(actual, expected) {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	})
+
+	t.Run("it reports broken pages", func(t *testing.T) {
+		srv := site(t)
+		c := &Crawler{}
+		data, err := c.Crawl(t.Context(), []string{srv.URL + "/missing", srv.URL + "/docs/deep"})
+		if err == nil {
+			t.Error("expected error, got none")
+		}
+		if len(data) != 1 {
+			t.Errorf("expected one page, got %v", crawled(srv, data))
+		}
+	})
+
+	t.Run("it waits between requests to a host", func(t *testing.T) {
+		srv := site(t)
+		c := &Crawler{
+			MaxDepth:     1,
+			Delay:        25 * time.Millisecond,
+			IgnoreRobots: true,
+			Downloader:   &Downloader{Concurrency: 4},
+		}
+
+		start := time.Now()
+		data, err := c.Crawl(t.Context(), []string{srv.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Three pages means at least two delays
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Errorf("crawled %v pages too quickly: %v", len(data), elapsed)
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package concurrentdownloads
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// partial is somewhere a download is written to, which can be resumed.
+type partial interface {
+	io.Writer
+	// size returns how much has been written so far.
+	size() (int64, error)
+	// reset discards everything written so far.
+	reset() error
+}
+
+// filePartial appends a download to a file.
+type filePartial struct {
+	*os.File
+}
+
+func (f filePartial) size() (int64, error) {
+	return f.Seek(0, io.SeekEnd)
+}
+
+func (f filePartial) reset() error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
+
+// downloadFile streams item into the file at part, resuming with a Range
+// request when part already holds the start of the download. Each retry
+// resumes from wherever the last attempt got to.
+func (d *Downloader) downloadFile(ctx context.Context, item Item, part string) error {
+	if err := os.MkdirAll(filepath.Dir(part), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return d.retry(ctx, func() error {
+		// Only resume if the file hasn't changed since we started it
+		record, _ := d.Journal.Lookup(item.URL)
+		return d.downloadPart(ctx, item, filePartial{f}, record.ETag, func(res *http.Response, offset int64) error {
+			return d.Journal.Record(JournalRecord{URL: item.URL, State: StateInFlight, ETag: res.Header.Get("ETag")})
+		})
+	})
+}
+
+// downloadPart makes a single request for the rest of the download, appending
+// it to p. When etag is set, the server only sends a partial response if it
+// still matches. Once the response is accepted, started is called with it and
+// the offset it starts from, before the body is copied.
+func (d *Downloader) downloadPart(ctx context.Context, item Item, p partial, etag string, started func(res *http.Response, offset int64) error) error {
+	offset, err := p.size()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, item.URL, nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range item.Header {
+		req.Header[key] = values
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// If the validator doesn't match, the server sends the whole thing
+		if etag != "" {
+			req.Header.Set("If-Range", etag)
+		}
+	}
+
+	res, _, err := d.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusPartialContent && contentRangeStart(res.Header) == offset:
+		// Resuming, so append to what we have
+	case offset > 0 && (res.StatusCode == http.StatusPartialContent || res.StatusCode == http.StatusRequestedRangeNotSatisfiable):
+		// Either the range doesn't line up with our part, or we can't tell
+		// whether the part is complete or stale, so start over
+		if err := p.reset(); err != nil {
+			return err
+		}
+		return d.downloadPart(ctx, item, p, "", started)
+	case res.StatusCode > 399:
+		return d.checkStatus(item.URL, res.StatusCode)
+	default:
+		// The server sent the whole file
+		if err := p.reset(); err != nil {
+			return err
+		}
+		offset = 0
+	}
+
+	// Only the start of the download can be sniffed
+	var body io.Reader = res.Body
+	var start []byte
+	if d.ContentTypes != nil && offset == 0 {
+		if start, body, err = peek(res.Body); err != nil {
+			return err
+		}
+	}
+	if err := d.ContentTypes.check(item.URL, res, start); err != nil {
+		d.Metrics.error(err)
+		return err
+	}
+
+	if started != nil {
+		if err := started(res, offset); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.Copy(p, body)
+	return err
+}
+
+// contentRangeStart returns the first byte position of a Content-Range
+// header, like "bytes 100-199/200", or -1 if it's missing or invalid.
+func contentRangeStart(header http.Header) int64 {
+	value, ok := strings.CutPrefix(header.Get("Content-Range"), "bytes ")
+	if !ok {
+		return -1
+	}
+	start, _, ok := strings.Cut(value, "-")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
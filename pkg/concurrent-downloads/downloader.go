@@ -0,0 +1,237 @@
+package concurrentdownloads
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultConcurrency sizes the worker pool for open ended work, like crawls,
+// where there's no item count to size it by.
+const defaultConcurrency = 4
+
+// Item is a URL to download, along with its scheduling hints.
+type Item struct {
+	URL string
+	// Priority orders the queue, higher priorities are downloaded first.
+	Priority int
+	// Deadline is optional, and orders items within the same priority,
+	// earliest first. Items without a deadline go after those with one.
+	Deadline time.Time
+	// Header is optional, and added to the request.
+	Header http.Header
+
+	// handle is set for items submitted to a Batch.
+	handle *Handle
+}
+
+// ErrorPolicy decides what happens to the rest of the downloads when one of
+// them fails.
+type ErrorPolicy int
+
+const (
+	// FailFast cancels everything else on the first error, and returns it.
+	FailFast ErrorPolicy = iota
+	// CollectErrors carries on with everything else, and returns every
+	// error joined together.
+	CollectErrors
+)
+
+// Downloader downloads items through a pool of workers, always taking the
+// highest priority item from the queue next.
+//
+// The zero value is ready to use, and behaves like [DownloadAll].
+type Downloader struct {
+	// Concurrency is the number of workers, defaults to one per item, or a
+	// small pool for crawls where the number of items isn't known up front.
+	Concurrency int
+	// Aging raises the priority of a queued item by one for every Aging it
+	// spends waiting, so low priority items aren't starved. Zero disables it.
+	Aging time.Duration
+	// ErrorPolicy decides whether one failed download stops the rest.
+	ErrorPolicy ErrorPolicy
+	// Retries is how many times a request is retried after a network error,
+	// rate limit or server error, with exponential backoff.
+	Retries int
+	// Metrics collects request counters and latencies when it's set.
+	Metrics *Metrics
+	// Tracer receives the timed phases of every request when it's set.
+	Tracer Tracer
+	// ContentTypes rejects downloads whose type isn't wanted, like HTML
+	// error pages served with a 200 status. Only the content being
+	// downloaded is checked, not what's read along the way, like
+	// robots.txt, sitemaps and directory listings. Crawled pages are
+	// checked, but rejected ones are still followed for links.
+	ContentTypes *ContentTypes
+	// Journal records the state of each item in [Downloader.RunManifest],
+	// so an interrupted batch can be resumed.
+	Journal *Journal
+
+	// Proxies route requests for matching hosts through a proxy, the first
+	// match wins. Without any, the proxy comes from the environment.
+	Proxies []Proxy
+	// NoProxy lists hosts which are never proxied, like NO_PROXY.
+	NoProxy []string
+	// Resolve overrides the address dialed for a "host" or "host:port",
+	// like curl's --resolve, with an "ip" or "ip:port".
+	Resolve map[string]string
+	// DNSCacheTTL caches host name lookups in-process for this long. Zero
+	// leaves lookups to the system.
+	DNSCacheTTL time.Duration
+	// TLS configures trusted CAs, client certificates and pinning for HTTPS.
+	TLS *TLSConfig
+	// Hooks are told when each item and each batch finishes.
+	Hooks *Hooks
+	// Session shares cookies between requests, and logs in before the
+	// first one when it has a Login hook.
+	Session *Session
+
+	transportOnce sync.Once
+	roundTripper  http.RoundTripper
+	transportErr  error
+}
+
+// DownloadAll returns a map of {url:data}, downloading all URLs with the same
+// priority.
+func (d *Downloader) DownloadAll(ctx context.Context, urls []string) (map[string]string, error) {
+	items := make([]Item, len(urls))
+	for i, url := range urls {
+		items[i] = Item{URL: url}
+	}
+	return d.DownloadItems(ctx, items)
+}
+
+// DownloadItems returns a map of {url:data}, downloading items in priority
+// order.
+func (d *Downloader) DownloadItems(ctx context.Context, items []Item) (map[string]string, error) {
+	results, err := d.Results(ctx, items)
+	data := make(map[string]string, len(results))
+	for url, result := range results {
+		data[url] = string(result.Body)
+	}
+	return data, err
+}
+
+// Results returns a map of {url:result}, downloading items in priority order.
+func (d *Downloader) Results(ctx context.Context, items []Item) (map[string]*Result, error) {
+	results := make(map[string]*Result, len(items))
+	mu := sync.Mutex{}
+
+	err := d.each(ctx, items, func(ctx context.Context, item Item) error {
+		result, err := d.download(ctx, item.URL, item.Header)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		results[item.URL] = result
+		mu.Unlock()
+		return nil
+	})
+	return results, err
+}
+
+// each calls fn for every item on a pool of workers, in priority order, and
+// handles its errors according to the ErrorPolicy. It's a batch as far as the
+// Hooks are concerned.
+func (d *Downloader) each(ctx context.Context, items []Item, fn func(ctx context.Context, item Item) error) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	errs := []error{}
+	mu := sync.Mutex{}
+	batch := d.Hooks.startBatch(d, len(items))
+
+	q := newQueue(d.Aging)
+	q.push(items...)
+	q.close()
+
+	d.run(ctx, q, d.workers(len(items)), func(item Item) {
+		start := time.Now()
+		err := fn(ctx, item)
+		batch.item(item.URL, start, err)
+		if err == nil {
+			return
+		}
+		if d.ErrorPolicy == CollectErrors {
+			if ctx.Err() != nil {
+				// Cancellation is reported once, not for every item
+				return
+			}
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+			return
+		}
+		if err != context.Canceled && err != context.DeadlineExceeded {
+			// Don't try to re-cancel if we're canceled already
+			cancel(err)
+		}
+	})
+
+	err := context.Cause(ctx)
+	if d.ErrorPolicy == CollectErrors {
+		err = errors.Join(append(errs, err)...)
+	}
+	batch.finish(ctx, err)
+	return err
+}
+
+// workers returns the size of the worker pool for n items.
+func (d *Downloader) workers(n int) int {
+	if d.Concurrency > 0 {
+		return d.Concurrency
+	}
+	return max(n, 1)
+}
+
+// run starts a pool of workers which call fn for each item popped from the
+// queue, and waits until the queue is drained or the context is done.
+func (d *Downloader) run(ctx context.Context, q *queue, workers int, fn func(Item)) {
+	// Wake up idle workers when we're canceled so they can exit
+	stop := context.AfterFunc(ctx, q.wake)
+	defer stop()
+
+	wg := sync.WaitGroup{}
+	for range workers {
+		wg.Go(func() {
+			for {
+				item, ok := q.pop(ctx)
+				if !ok {
+					return
+				}
+				fn(item)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// walk is like run, except fn may push more items as it goes, and the queue
+// is closed once every item has been handled.
+func (d *Downloader) walk(ctx context.Context, items []Item, fn func(item Item, push func(Item))) {
+	if len(items) == 0 {
+		return
+	}
+
+	pending := atomic.Int64{}
+	pending.Store(int64(len(items)))
+
+	q := newQueue(d.Aging)
+	push := func(item Item) {
+		pending.Add(1)
+		q.push(item)
+	}
+	q.push(items...)
+
+	d.run(ctx, q, d.workers(defaultConcurrency), func(item Item) {
+		fn(item, push)
+		// Anything fn pushed is already pending, so this only reaches zero
+		// once there's nothing left to do
+		if pending.Add(-1) == 0 {
+			q.close()
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package concurrentdownloads_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestDownloader(t *testing.T) {
+	// recorder serves every path and records the order they were requested in
+	recorder := func(t *testing.T) (*httptest.Server, func() []string) {
+		mu := sync.Mutex{}
+		paths := []string{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			paths = append(paths, r.URL.Path)
+			mu.Unlock()
+			w.Write([]byte(r.URL.Path))
+		}))
+		t.Cleanup(srv.Close)
+		return srv, func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return slices.Clone(paths)
+		}
+	}
+
+	t.Run("it downloads items", func(t *testing.T) {
+		srv, _ := recorder(t)
+		d := &Downloader{Concurrency: 2}
+		data, err := d.DownloadAll(t.Context(), []string{srv.URL + "/a", srv.URL + "/b", srv.URL + "/c"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 3 {
+			t.Errorf("data is the wrong length: %v != %v", len(data), 3)
+		}
+		if data[srv.URL+"/b"] != "/b" {
+			t.Errorf("unexpected body: %q", data[srv.URL+"/b"])
+		}
+	})
+
+	t.Run("it takes the highest priority first", func(t *testing.T) {
+		srv, paths := recorder(t)
+		now := time.Now()
+		items := []Item{
+			{URL: srv.URL + "/bulk-1"},
+			{URL: srv.URL + "/bulk-2", Priority: -1},
+			{URL: srv.URL + "/late", Priority: 5, Deadline: now.Add(time.Hour)},
+			{URL: srv.URL + "/index", Priority: 10},
+			{URL: srv.URL + "/undated", Priority: 5},
+			{URL: srv.URL + "/soon", Priority: 5, Deadline: now.Add(time.Minute)},
+			{URL: srv.URL + "/bulk-3"},
+		}
+
+		d := &Downloader{Concurrency: 1}
+		if _, err := d.DownloadItems(t.Context(), items); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{"/index", "/soon", "/late", "/undated", "/bulk-1", "/bulk-3", "/bulk-2"}
+		if actual := paths(); !slices.Equal(actual, expected) {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	})
+
+	t.Run("it stops when a download fails", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		defer srv.Close()
+
+		d := &Downloader{Concurrency: 1}
+		_, err := d.DownloadAll(t.Context(), []string{srv.URL + "/missing", srv.URL + "/other"})
+		if err == nil {
+			t.Fatal("expected error, got none")
+		}
+	})
+
+	t.Run("it collects errors when asked to", func(t *testing.T) {
+		srv, _ := recorder(t)
+		missing := httptest.NewServer(http.NotFoundHandler())
+		defer missing.Close()
+
+		d := &Downloader{Concurrency: 1, ErrorPolicy: CollectErrors}
+		data, err := d.DownloadAll(t.Context(), []string{missing.URL + "/a", srv.URL + "/b", missing.URL + "/c"})
+		var status *StatusError
+		if !errors.As(err, &status) {
+			t.Fatalf("expected a status error, got %v", err)
+		}
+		if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 2 {
+			t.Errorf("expected 2 errors, got %v: %v", n, err)
+		}
+		if data[srv.URL+"/b"] != "/b" {
+			t.Errorf("expected the rest to download, got %v", data)
+		}
+	})
+}
@@ -0,0 +1,287 @@
+// Package downloadtest provides a local HTTP origin for testing downloads,
+// which can be scripted to misbehave in repeatable ways: slow responses,
+// sequences of statuses, truncated bodies, slow drips and connection resets.
+// It counts requests and tracks how many are in flight, so retries,
+// concurrency limits and cancellation can be tested without the internet.
+package downloadtest
+
+import (
+	"cmp"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// awaitTimeout is how long the Await helpers wait before failing the test.
+const awaitTimeout = 10 * time.Second
+
+// Response is one scripted response.
+type Response struct {
+	// Status defaults to 200.
+	Status int
+	Header http.Header
+	Body   string
+	// Latency delays the response headers.
+	Latency time.Duration
+	// Gate holds the response, after Latency, until it's closed. It's how a
+	// test keeps requests in flight while it checks on them.
+	Gate <-chan struct{}
+	// Drip sends the body a chunk at a time, waiting Drip before each one.
+	Drip time.Duration
+	// DripSize is the size of each chunk, defaulting to one byte.
+	DripSize int
+	// Truncate cuts the connection after this many bytes of the body, while
+	// the Content-Length still promises all of it. Zero sends it all.
+	Truncate int
+	// Reset resets the connection instead of responding, after Latency.
+	Reset bool
+}
+
+// Server is a local HTTP origin which plays back scripted responses for each
+// path. Paths without a script are 404s.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	scripts  map[string][]Response
+	requests map[string]int
+	inFlight int
+	peak     int
+	// changed is closed and replaced whenever the counts change
+	changed chan struct{}
+}
+
+// NewServer starts a server, which is closed when the test finishes.
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		scripts:  map[string][]Response{},
+		requests: map[string]int{},
+		changed:  make(chan struct{}),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Handle scripts the responses for path. Each request gets the next response
+// in turn, and the last one repeats, so "fail twice, then succeed" is two
+// failures followed by a success.
+func (s *Server) Handle(path string, responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[path] = responses
+}
+
+// URL returns the URL for path on the server.
+func (s *Server) URL(path string) string {
+	return s.Server.URL + path
+}
+
+// Requests returns how many requests path has had.
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// TotalRequests returns how many requests the server has had.
+func (s *Server) TotalRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := 0
+	for _, n := range s.requests {
+		total += n
+	}
+	return total
+}
+
+// InFlight returns how many requests are being handled right now.
+func (s *Server) InFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inFlight
+}
+
+// Peak returns the most requests that have been in flight at once.
+func (s *Server) Peak() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peak
+}
+
+// AssertRequests fails the test unless path has had exactly n requests.
+func (s *Server) AssertRequests(t testing.TB, path string, n int) {
+	t.Helper()
+	if got := s.Requests(path); got != n {
+		t.Errorf("expected %d requests for %s, got %d", n, path, got)
+	}
+}
+
+// AssertPeak fails the test if more than n requests were ever in flight at
+// once.
+func (s *Server) AssertPeak(t testing.TB, n int) {
+	t.Helper()
+	if got := s.Peak(); got > n {
+		t.Errorf("expected at most %d requests in flight, got %d", n, got)
+	}
+}
+
+// AwaitInFlight waits until at least n requests are in flight, failing the
+// test if that takes too long.
+func (s *Server) AwaitInFlight(t testing.TB, n int) {
+	t.Helper()
+	s.await(t, func() bool { return s.inFlight >= n }, "%d requests in flight", n)
+}
+
+// AwaitRequests waits until the server has had at least n requests, failing
+// the test if that takes too long.
+func (s *Server) AwaitRequests(t testing.TB, n int) {
+	t.Helper()
+	s.await(t, func() bool {
+		total := 0
+		for _, n := range s.requests {
+			total += n
+		}
+		return total >= n
+	}, "%d requests", n)
+}
+
+// await waits for done, which is called with s.mu held, to return true.
+func (s *Server) await(t testing.TB, done func() bool, format string, args ...any) {
+	t.Helper()
+	timeout := time.NewTimer(awaitTimeout)
+	defer timeout.Stop()
+	for {
+		s.mu.Lock()
+		ok, changed := done(), s.changed
+		s.mu.Unlock()
+		if ok {
+			return
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			t.Fatalf("timed out waiting for "+format, args...)
+		}
+	}
+}
+
+// start counts a request, and returns its response.
+func (s *Server) start(path string) (Response, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.requests[path]
+	s.requests[path]++
+	s.inFlight++
+	s.peak = max(s.peak, s.inFlight)
+	s.notify()
+
+	script, ok := s.scripts[path]
+	if !ok || len(script) == 0 {
+		return Response{}, false
+	}
+	return script[min(n, len(script)-1)], true
+}
+
+func (s *Server) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	s.notify()
+}
+
+// notify wakes anything waiting for the counts to change. The caller holds
+// s.mu.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	res, ok := s.start(r.URL.Path)
+	defer s.finish()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	if !sleep(ctx.Done(), res.Latency) {
+		return
+	}
+	if res.Gate != nil {
+		select {
+		case <-res.Gate:
+		case <-ctx.Done():
+			return
+		}
+	}
+	if res.Reset {
+		reset(w)
+		return
+	}
+
+	for key, values := range res.Header {
+		w.Header()[key] = values
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(res.Body)))
+	w.WriteHeader(cmp.Or(res.Status, http.StatusOK))
+
+	body := res.Body
+	if res.Truncate > 0 {
+		body = body[:min(res.Truncate, len(body))]
+	}
+	if res.Drip > 0 {
+		size := max(res.DripSize, 1)
+		rc := http.NewResponseController(w)
+		for len(body) > 0 {
+			if !sleep(ctx.Done(), res.Drip) {
+				return
+			}
+			n := min(size, len(body))
+			w.Write([]byte(body[:n]))
+			rc.Flush()
+			body = body[n:]
+		}
+	} else {
+		w.Write([]byte(body))
+	}
+
+	if res.Truncate > 0 {
+		// Flush what was sent, then drop the connection short of the
+		// Content-Length
+		http.NewResponseController(w).Flush()
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// sleep waits for d, and reports whether it finished before done.
+func sleep(done <-chan struct{}, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// reset closes the connection with a TCP reset rather than a clean close.
+func reset(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
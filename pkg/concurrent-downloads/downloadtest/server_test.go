@@ -0,0 +1,116 @@
+package downloadtest_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads/downloadtest"
+)
+
+func TestServer(t *testing.T) {
+	get := func(t *testing.T, url string) (*http.Response, string, error) {
+		res, err := http.Get(url)
+		if err != nil {
+			return nil, "", err
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		return res, string(body), err
+	}
+
+	t.Run("it plays back a sequence of responses", func(t *testing.T) {
+		s := NewServer(t)
+		s.Handle("/flaky",
+			Response{Status: http.StatusServiceUnavailable},
+			Response{Status: http.StatusServiceUnavailable},
+			Response{Body: "ok"},
+		)
+
+		statuses := []int{}
+		for range 4 {
+			res, _, err := get(t, s.URL("/flaky"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			statuses = append(statuses, res.StatusCode)
+		}
+		if statuses[0] != 503 || statuses[1] != 503 || statuses[2] != 200 || statuses[3] != 200 {
+			t.Errorf("unexpected statuses %v", statuses)
+		}
+		s.AssertRequests(t, "/flaky", 4)
+	})
+
+	t.Run("it returns 404 for unscripted paths", func(t *testing.T) {
+		s := NewServer(t)
+		res, _, err := get(t, s.URL("/missing"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusNotFound || s.TotalRequests() != 1 {
+			t.Errorf("expected one 404, got %v after %v requests", res.StatusCode, s.TotalRequests())
+		}
+	})
+
+	t.Run("it truncates bodies", func(t *testing.T) {
+		s := NewServer(t)
+		s.Handle("/short", Response{Body: "0123456789", Truncate: 4})
+
+		_, body, err := get(t, s.URL("/short"))
+		if !errors.Is(err, io.ErrUnexpectedEOF) || body != "0123" {
+			t.Errorf("expected a truncated body, got %q and %v", body, err)
+		}
+	})
+
+	t.Run("it drips bodies", func(t *testing.T) {
+		s := NewServer(t)
+		s.Handle("/slow", Response{Body: "abcd", Drip: 10 * time.Millisecond, DripSize: 2})
+
+		start := time.Now()
+		_, body, err := get(t, s.URL("/slow"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != "abcd" || time.Since(start) < 20*time.Millisecond {
+			t.Errorf("expected a slow body, got %q in %v", body, time.Since(start))
+		}
+	})
+
+	t.Run("it resets connections", func(t *testing.T) {
+		s := NewServer(t)
+		s.Handle("/reset", Response{Reset: true})
+
+		if _, _, err := get(t, s.URL("/reset")); err == nil {
+			t.Error("expected a connection error")
+		}
+	})
+
+	t.Run("it tracks requests in flight", func(t *testing.T) {
+		s := NewServer(t)
+		gate := make(chan struct{})
+		s.Handle("/held", Response{Gate: gate, Body: "ok"})
+
+		done := make(chan error, 3)
+		for range 3 {
+			go func() {
+				_, _, err := get(t, s.URL("/held"))
+				done <- err
+			}()
+		}
+		s.AwaitInFlight(t, 3)
+		if s.InFlight() != 3 {
+			t.Errorf("expected 3 requests in flight, got %v", s.InFlight())
+		}
+		close(gate)
+		for range 3 {
+			if err := <-done; err != nil {
+				t.Error(err)
+			}
+		}
+		if s.Peak() != 3 {
+			t.Errorf("expected a peak of 3, got %v", s.Peak())
+		}
+	})
+}
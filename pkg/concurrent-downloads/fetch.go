@@ -0,0 +1,235 @@
+package concurrentdownloads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// retryBackoff is the delay before the first retry, doubling for each one
+// after that.
+const retryBackoff = 100 * time.Millisecond
+
+// StatusError is returned when a server responds with an error status.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("error fetching URL: %s, status code: %d", e.URL, e.StatusCode)
+}
+
+// statusError returns a StatusError for url when statusCode is an error.
+func statusError(url string, statusCode int) error {
+	if statusCode > 399 {
+		return &StatusError{URL: url, StatusCode: statusCode}
+	}
+	return nil
+}
+
+// checkStatus is like statusError, and counts the error in the Metrics.
+func (d *Downloader) checkStatus(url string, statusCode int) error {
+	err := statusError(url, statusCode)
+	if err != nil {
+		d.Metrics.error(err)
+	}
+	return err
+}
+
+// Result is a completed download.
+type Result struct {
+	URL string
+	// Response is the final response, after any redirects. Its body has
+	// already been read into Body.
+	Response *http.Response
+	Body     []byte
+	Timing   Timing
+	// RequestHeader holds the header fields as they were sent for the final
+	// request, including the ones the transport adds, like Host, User-Agent
+	// and Accept-Encoding.
+	RequestHeader http.Header
+}
+
+// Fetch downloads url, and returns the result with its timing breakdown.
+func (d *Downloader) Fetch(ctx context.Context, url string) (*Result, error) {
+	return d.download(ctx, url, nil)
+}
+
+// FetchURL returns the body of url, or an error if the request fails or the
+// server responds with an error status.
+func (d *Downloader) FetchURL(ctx context.Context, url string) ([]byte, error) {
+	result, err := d.download(ctx, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return result.Body, nil
+}
+
+// download fetches url for its payload, which is checked against the
+// ContentTypes rules. Requests the Downloader makes for itself, like for
+// robots.txt, sitemaps and listings, use fetch so the rules don't apply.
+func (d *Downloader) download(ctx context.Context, url string, header http.Header) (*Result, error) {
+	result, err := d.fetch(ctx, url, header)
+	if err != nil {
+		return result, err
+	}
+	if err := d.ContentTypes.check(url, result.Response, result.Body); err != nil {
+		d.Metrics.error(err)
+		return result, err
+	}
+	return result, nil
+}
+
+// fetch requests url with the given headers, retrying failures up to Retries
+// times.
+func (d *Downloader) fetch(ctx context.Context, url string, header http.Header) (*Result, error) {
+	var result *Result
+	err := d.retry(ctx, func() (err error) {
+		result, err = d.fetchOnce(ctx, url, header)
+		return err
+	})
+	return result, err
+}
+
+// retry calls fn until it succeeds, fails with an error that isn't worth
+// retrying, or has been retried Retries times.
+func (d *Downloader) retry(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= d.Retries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		d.Metrics.retry()
+		timer := time.NewTimer(retryBackoff << attempt)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// fetchOnce makes a single request. When the server responds with an error
+// status, the result is returned along with the error.
+func (d *Downloader) fetchOnce(ctx context.Context, url string, header http.Header) (*Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	res, t, err := d.do(req)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{URL: url, Response: res, RequestHeader: t.sentHeader()}
+
+	// Make errors happen for testing, mostly
+	if err := d.checkStatus(url, res.StatusCode); err != nil {
+		res.Body.Close()
+		result.Timing = t.Timing()
+		return result, err
+	}
+
+	result.Body, err = io.ReadAll(res.Body)
+	res.Body.Close()
+	result.Timing = t.Timing()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// do sends a single request, tracing it for the metrics and Tracer. The timing
+// is complete once the response body is closed.
+func (d *Downloader) do(req *http.Request) (*http.Response, *timing, error) {
+	transport, err := d.transport()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := d.Session.login(req.Context(), transport); err != nil {
+		return nil, nil, err
+	}
+	client := http.Client{Transport: transport, Jar: d.Session.cookieJar()}
+
+	t := &timing{}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), t.trace()))
+	t.start = time.Now()
+
+	host := req.URL.Host
+	d.Metrics.request()
+	res, err := client.Do(req)
+	if err != nil {
+		d.Metrics.error(err)
+		return nil, nil, err
+	}
+	d.Metrics.response(res.StatusCode)
+
+	res.Body = &tracedBody{
+		ReadCloser: res.Body,
+		done: func(n int64, err error) {
+			t.finish()
+			if err != nil {
+				d.Metrics.error(err)
+			}
+			timing := t.Timing()
+			d.Metrics.observe(host, timing, n)
+			if d.Tracer != nil {
+				for _, span := range t.spans(req.URL.String()) {
+					d.Tracer.Span(req.Context(), span)
+				}
+			}
+		},
+	}
+	return res, t, nil
+}
+
+// retryable reports whether a failed request is worth trying again, which is
+// the case for server errors, rate limits and network failures.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.StatusCode > 499 || status.StatusCode == http.StatusTooManyRequests
+	}
+	var decode *DecodeError
+	var contentType *ContentTypeError
+	return !errors.As(err, &decode) && !errors.As(err, &contentType)
+}
+
+// tracedBody counts the bytes read from a response body, and calls done once
+// when it's closed.
+type tracedBody struct {
+	io.ReadCloser
+	n    int64
+	err  error
+	once sync.Once
+	done func(n int64, err error)
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.n, b.err) })
+	return err
+}
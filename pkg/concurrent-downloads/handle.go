@@ -0,0 +1,360 @@
+package concurrentdownloads
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrDownloadCanceled is returned from [Handle.Wait] when the download was
+// canceled through its handle.
+var ErrDownloadCanceled = errors.New("download canceled")
+
+// errPaused is the cause used to stop a running download when it's paused.
+var errPaused = errors.New("download paused")
+
+// HandleState is the state of a download submitted to a [Batch].
+type HandleState string
+
+const (
+	HandleQueued   HandleState = "queued"
+	HandleRunning  HandleState = "running"
+	HandlePaused   HandleState = "paused"
+	HandleDone     HandleState = "done"
+	HandleFailed   HandleState = "failed"
+	HandleCanceled HandleState = "canceled"
+)
+
+// HandleStatus is a snapshot of a download's progress.
+type HandleStatus struct {
+	URL   string
+	State HandleState
+	// Received is the number of bytes downloaded so far.
+	Received int64
+	// Total is the size of the download, or -1 while it's unknown.
+	Total int64
+	Err   error
+}
+
+// Batch runs downloads submitted to it on the Downloader's worker pool, until
+// it's closed. Each download is controlled through its [Handle].
+type Batch struct {
+	d      *Downloader
+	q      *queue
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	hooks  *batchHooks
+
+	mu      sync.Mutex
+	handles map[*Handle]bool
+	closed  bool
+}
+
+// Handle controls a single download in a [Batch].
+type Handle struct {
+	batch *Batch
+	item  Item
+	done  chan struct{}
+
+	mu       sync.Mutex
+	state    HandleState
+	queued   bool
+	running  bool
+	buf      bytes.Buffer
+	total    int64
+	etag     string
+	err      error
+	stop     context.CancelCauseFunc
+	finished bool
+	// started is when the download first started, and reported is set once
+	// it has been passed to the Hooks. Handles which never started count
+	// as skipped.
+	started  time.Time
+	reported bool
+}
+
+// Start starts a batch which downloads submitted items until ctx is done or
+// the batch is closed.
+func (d *Downloader) Start(ctx context.Context) *Batch {
+	ctx, cancel := context.WithCancel(ctx)
+	b := &Batch{
+		d:       d,
+		q:       newQueue(d.Aging),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		hooks:   d.Hooks.startBatch(d, 0),
+		handles: map[*Handle]bool{},
+	}
+
+	go func() {
+		defer close(b.done)
+		d.run(ctx, b.q, d.workers(defaultConcurrency), func(item Item) {
+			item.handle.run()
+		})
+		// Whatever is left was never going to finish
+		b.mu.Lock()
+		handles := slices.Collect(maps.Keys(b.handles))
+		b.mu.Unlock()
+		for _, h := range handles {
+			h.mu.Lock()
+			h.finish(HandleCanceled, context.Cause(ctx))
+			h.mu.Unlock()
+			h.report()
+		}
+
+		// Closing the batch is how it normally ends, so it's not an error
+		b.mu.Lock()
+		var err error
+		if !b.closed {
+			err = context.Cause(ctx)
+		}
+		b.mu.Unlock()
+		b.hooks.finish(ctx, err)
+	}()
+	return b
+}
+
+// Submit queues item for download, and returns its handle. Submitting to a
+// closed batch returns a handle which has already been canceled.
+func (b *Batch) Submit(item Item) *Handle {
+	h := &Handle{batch: b, item: item, done: make(chan struct{}), state: HandleQueued, total: -1}
+	b.hooks.add(1)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.enqueue()
+	return h
+}
+
+// Close cancels anything which hasn't finished, and waits for the workers to
+// stop.
+func (b *Batch) Close() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+
+	b.cancel()
+	<-b.done
+}
+
+// Cancel stops the download for good. Wait returns [ErrDownloadCanceled].
+func (h *Handle) Cancel() {
+	defer h.report()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch h.state {
+	case HandleRunning:
+		h.stop(ErrDownloadCanceled)
+	case HandleQueued, HandlePaused:
+		h.finish(HandleCanceled, ErrDownloadCanceled)
+	}
+}
+
+// Pause stops the download, keeping what has been received so far, until it's
+// resumed.
+func (h *Handle) Pause() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch h.state {
+	case HandleRunning:
+		h.state = HandlePaused
+		h.stop(errPaused)
+	case HandleQueued:
+		h.state = HandlePaused
+	}
+}
+
+// Resume queues a paused download again. It continues from where it stopped
+// with a Range request when the server supports it, and starts over when it
+// doesn't.
+func (h *Handle) Resume() {
+	defer h.report()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.state != HandlePaused {
+		return
+	}
+	h.state = HandleQueued
+	// If it's still queued, or still winding down from the pause, it'll
+	// pick up where it left off without queueing it again
+	if !h.queued && !h.running {
+		h.enqueue()
+	}
+}
+
+// enqueue pushes the handle onto the batch's queue, or cancels it if the batch
+// is closed or its context is done. The handle must be locked.
+func (h *Handle) enqueue() {
+	b := h.batch
+	b.mu.Lock()
+	// Once the context is done, the workers may already have finished off
+	// every handle they knew about
+	closed := b.closed || b.ctx.Err() != nil
+	if !closed {
+		b.handles[h] = true
+		h.queued = true
+		item := h.item
+		item.handle = h
+		b.q.push(item)
+	}
+	b.mu.Unlock()
+
+	if closed {
+		h.finish(HandleCanceled, cmp.Or(context.Cause(b.ctx), context.Canceled))
+	}
+}
+
+// Wait blocks until the download has finished, and returns its body.
+func (h *Handle) Wait(ctx context.Context) ([]byte, error) {
+	select {
+	case <-h.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err != nil {
+		return nil, h.err
+	}
+	return bytes.Clone(h.buf.Bytes()), nil
+}
+
+// Done returns a channel which is closed when the download has finished.
+func (h *Handle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Status returns a snapshot of the download's progress.
+func (h *Handle) Status() HandleStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return HandleStatus{
+		URL:      h.item.URL,
+		State:    h.state,
+		Received: int64(h.buf.Len()),
+		Total:    h.total,
+		Err:      h.err,
+	}
+}
+
+// run downloads the item on a worker, unless it was paused or canceled while
+// it was queued.
+func (h *Handle) run() {
+	defer h.report()
+	h.mu.Lock()
+	h.queued = false
+	if h.state != HandleQueued {
+		h.mu.Unlock()
+		return
+	}
+	ctx, stop := context.WithCancelCause(h.batch.ctx)
+	defer stop(nil)
+	h.state = HandleRunning
+	h.running = true
+	if h.started.IsZero() {
+		h.started = time.Now()
+	}
+	h.stop = stop
+	h.mu.Unlock()
+
+	d := h.batch.d
+	err := d.retry(ctx, func() error {
+		h.mu.Lock()
+		etag := h.etag
+		h.mu.Unlock()
+		return d.downloadPart(ctx, h.item, handlePartial{h}, etag, func(res *http.Response, offset int64) error {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.etag = res.Header.Get("ETag")
+			if res.ContentLength >= 0 {
+				h.total = offset + res.ContentLength
+			}
+			return nil
+		})
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = false
+	switch cause := context.Cause(ctx); {
+	case err == nil:
+		h.finish(HandleDone, nil)
+	case cause == errPaused:
+		// Keep what we have for when it's resumed, which may have already
+		// happened while we were stopping
+		if h.state == HandleQueued {
+			h.enqueue()
+		}
+	case cause == ErrDownloadCanceled:
+		h.finish(HandleCanceled, ErrDownloadCanceled)
+	case cause != nil:
+		h.finish(HandleCanceled, cause)
+	default:
+		h.finish(HandleFailed, err)
+	}
+}
+
+// finish settles the handle, which must be locked. Only the first call counts.
+func (h *Handle) finish(state HandleState, err error) {
+	if h.finished {
+		return
+	}
+	h.finished = true
+	h.state = state
+	h.err = err
+	close(h.done)
+
+	b := h.batch
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.handles, h)
+}
+
+// report passes a finished download to the batch's Hooks, once. It's called
+// without the handle locked, so the hooks are free to use it.
+func (h *Handle) report() {
+	h.mu.Lock()
+	if !h.finished || h.reported || h.started.IsZero() {
+		h.mu.Unlock()
+		return
+	}
+	h.reported = true
+	err := h.err
+	h.mu.Unlock()
+	h.batch.hooks.item(h.item.URL, h.started, err)
+}
+
+// handlePartial writes a download into its handle's buffer, under its lock so
+// Status can be read while it's running.
+type handlePartial struct {
+	h *Handle
+}
+
+func (p handlePartial) Write(data []byte) (int, error) {
+	p.h.mu.Lock()
+	defer p.h.mu.Unlock()
+	return p.h.buf.Write(data)
+}
+
+func (p handlePartial) size() (int64, error) {
+	p.h.mu.Lock()
+	defer p.h.mu.Unlock()
+	return int64(p.h.buf.Len()), nil
+}
+
+func (p handlePartial) reset() error {
+	p.h.mu.Lock()
+	defer p.h.mu.Unlock()
+	p.h.buf.Reset()
+	p.h.total = -1
+	return nil
+}
@@ -0,0 +1,185 @@
+package concurrentdownloads_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestBatch(t *testing.T) {
+	content := strings.Repeat("x", 100)
+
+	// The server drips the content out slowly, and supports resuming with
+	// Range requests
+	mu := sync.Mutex{}
+	ranges := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := 0
+		if value := r.Header.Get("Range"); value != "" {
+			mu.Lock()
+			ranges = append(ranges, value)
+			mu.Unlock()
+			start, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(value, "bytes="), "-"))
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)-start))
+		if start > 0 {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		for i := start; i < len(content); i += 10 {
+			w.Write([]byte(content[i:min(i+10, len(content))]))
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	waitFor := func(t *testing.T, h *Handle, check func(HandleStatus) bool) HandleStatus {
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			if status := h.Status(); check(status) {
+				return status
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("timeout, status: %+v", h.Status())
+		return HandleStatus{}
+	}
+
+	t.Run("it downloads submitted items", func(t *testing.T) {
+		b := (&Downloader{Concurrency: 2}).Start(t.Context())
+		defer b.Close()
+
+		handles := []*Handle{}
+		for i := range 3 {
+			handles = append(handles, b.Submit(Item{URL: fmt.Sprintf("%s/%d", srv.URL, i)}))
+		}
+		for _, h := range handles {
+			body, err := h.Wait(t.Context())
+			if err != nil || string(body) != content {
+				t.Errorf("unexpected result: %q, %v", body, err)
+			}
+			if status := h.Status(); status.State != HandleDone || status.Received != 100 || status.Total != 100 {
+				t.Errorf("unexpected status: %+v", status)
+			}
+		}
+	})
+
+	t.Run("it pauses and resumes", func(t *testing.T) {
+		b := (&Downloader{}).Start(t.Context())
+		defer b.Close()
+
+		h := b.Submit(Item{URL: srv.URL + "/pause"})
+		other := b.Submit(Item{URL: srv.URL + "/other"})
+		waitFor(t, h, func(s HandleStatus) bool { return s.Received >= 30 })
+
+		h.Pause()
+		paused := waitFor(t, h, func(s HandleStatus) bool { return s.State == HandlePaused })
+		time.Sleep(30 * time.Millisecond)
+		if status := h.Status(); status.Received != paused.Received || status.State != HandlePaused {
+			t.Errorf("expected the download to stop, got %+v then %+v", paused, status)
+		}
+
+		// The rest of the batch keeps going
+		if _, err := other.Wait(t.Context()); err != nil {
+			t.Error(err)
+		}
+
+		h.Resume()
+		body, err := h.Wait(t.Context())
+		if err != nil || string(body) != content {
+			t.Errorf("unexpected result: %q, %v", body, err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if len(ranges) == 0 || ranges[len(ranges)-1] != fmt.Sprintf("bytes=%d-", paused.Received) {
+			t.Errorf("expected to resume from %v, got %v", paused.Received, ranges)
+		}
+	})
+
+	t.Run("it cancels single items", func(t *testing.T) {
+		b := (&Downloader{Concurrency: 1}).Start(t.Context())
+		defer b.Close()
+
+		running := b.Submit(Item{URL: srv.URL + "/running"})
+		queued := b.Submit(Item{URL: srv.URL + "/queued"})
+		other := b.Submit(Item{URL: srv.URL + "/other"})
+		waitFor(t, running, func(s HandleStatus) bool { return s.State == HandleRunning })
+
+		queued.Cancel()
+		running.Cancel()
+		for _, h := range []*Handle{running, queued} {
+			if _, err := h.Wait(t.Context()); !errors.Is(err, ErrDownloadCanceled) {
+				t.Errorf("expected ErrDownloadCanceled, got %v", err)
+			}
+			if h.Status().State != HandleCanceled {
+				t.Errorf("unexpected status: %+v", h.Status())
+			}
+		}
+		if _, err := other.Wait(t.Context()); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("it reports failures", func(t *testing.T) {
+		missing := httptest.NewServer(http.NotFoundHandler())
+		defer missing.Close()
+
+		b := (&Downloader{}).Start(t.Context())
+		defer b.Close()
+
+		h := b.Submit(Item{URL: missing.URL})
+		var statusErr *StatusError
+		if _, err := h.Wait(t.Context()); !errors.As(err, &statusErr) {
+			t.Errorf("expected StatusError, got %v", err)
+		}
+		if h.Status().State != HandleFailed {
+			t.Errorf("unexpected status: %+v", h.Status())
+		}
+	})
+
+	t.Run("it cancels items submitted after the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		b := (&Downloader{}).Start(ctx)
+		defer b.Close()
+		cancel()
+
+		h := b.Submit(Item{URL: srv.URL})
+		if state := h.Status().State; state != HandleCanceled {
+			t.Errorf("expected the handle to be canceled, got %v", state)
+		}
+		if _, err := h.Wait(t.Context()); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("closing cancels what's left", func(t *testing.T) {
+		b := (&Downloader{}).Start(t.Context())
+		h := b.Submit(Item{URL: srv.URL + "/paused"})
+		h.Pause()
+		b.Close()
+
+		if _, err := h.Wait(t.Context()); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+		if late := b.Submit(Item{URL: srv.URL}); late.Status().State != HandleCanceled {
+			t.Errorf("unexpected status: %+v", late.Status())
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package concurrentdownloads
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultWebhookRetries is how many times a webhook is retried when Hooks
+// doesn't say.
+const defaultWebhookRetries = 3
+
+// webhookTimeout bounds how long a webhook can take, including retries.
+const webhookTimeout = time.Minute
+
+// Hooks are told when each item and each batch finishes. A batch is a single
+// call like [Downloader.DownloadAll], [Downloader.RunManifest],
+// [Crawler.Crawl], [LinkChecker.Check] or [LoadTest.Run], a [Pipeline] from
+// start to finish, or a [Batch] from Start to Close.
+type Hooks struct {
+	// OnItem is called from the worker as each item finishes.
+	OnItem func(ItemSummary)
+	// OnBatch is called once the batch has finished.
+	OnBatch func(BatchSummary)
+	// Webhook is a URL the batch summary is POSTed to as JSON.
+	Webhook string
+	// WebhookRetries is how many times a failed webhook is retried, with
+	// exponential backoff. Zero retries 3 times, and -1 disables retries.
+	WebhookRetries int
+	// SummaryFile is a file the batch summary is written to as JSON.
+	SummaryFile string
+	// OnError is called with any error writing the SummaryFile or sending
+	// the Webhook. These don't change the outcome of the batch, so they're
+	// dropped without it.
+	OnError func(error)
+}
+
+// ItemSummary describes an item once it has finished.
+type ItemSummary struct {
+	URL      string        `json:"url"`
+	Err      error         `json:"-"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// BatchSummary describes a batch once it has finished.
+type BatchSummary struct {
+	Items     int `json:"items"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	// Skipped counts the items which never started, because the batch was
+	// canceled or failed first.
+	Skipped  int           `json:"skipped"`
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Duration time.Duration `json:"duration_ns"`
+	Err      error         `json:"-"`
+	Error    string        `json:"error,omitempty"`
+	Failures []ItemSummary `json:"failures,omitempty"`
+}
+
+// batchHooks tracks a batch for its hooks. A nil *batchHooks does nothing.
+type batchHooks struct {
+	hooks *Hooks
+	d     *Downloader
+
+	mu      sync.Mutex
+	summary BatchSummary
+}
+
+// startBatch starts tracking a batch of n items.
+func (h *Hooks) startBatch(d *Downloader, n int) *batchHooks {
+	if h == nil {
+		return nil
+	}
+	return &batchHooks{hooks: h, d: d, summary: BatchSummary{Items: n, Started: time.Now()}}
+}
+
+// add counts n more items, for batches which don't know their size up front.
+func (b *batchHooks) add(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.summary.Items += n
+}
+
+// item records an item which started at start and finished with err.
+func (b *batchHooks) item(url string, start time.Time, err error) {
+	if b == nil {
+		return
+	}
+	item := ItemSummary{URL: url, Err: err, Duration: time.Since(start)}
+	if err != nil {
+		item.Error = err.Error()
+	}
+
+	b.mu.Lock()
+	if err != nil {
+		b.summary.Failed++
+		b.summary.Failures = append(b.summary.Failures, item)
+	} else {
+		b.summary.Succeeded++
+	}
+	b.mu.Unlock()
+
+	if b.hooks.OnItem != nil {
+		b.hooks.OnItem(item)
+	}
+}
+
+// finish completes the summary with the batch's error, and sends it to each
+// hook. Errors sending it go to OnError rather than the batch.
+func (b *batchHooks) finish(ctx context.Context, err error) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	summary := b.summary
+	b.mu.Unlock()
+	summary.Finished = time.Now()
+	summary.Duration = summary.Finished.Sub(summary.Started)
+	summary.Skipped = summary.Items - summary.Succeeded - summary.Failed
+	summary.Err = err
+	if err != nil {
+		summary.Error = err.Error()
+	}
+
+	if b.hooks.OnBatch != nil {
+		b.hooks.OnBatch(summary)
+	}
+	if b.hooks.Webhook == "" && b.hooks.SummaryFile == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		b.error(err)
+		return
+	}
+	if b.hooks.SummaryFile != "" {
+		if err := os.WriteFile(b.hooks.SummaryFile, append(data, '\n'), 0o644); err != nil {
+			b.error(fmt.Errorf("error writing summary: %w", err))
+		}
+	}
+	if b.hooks.Webhook != "" {
+		// The batch may have ended because it was canceled, which is when
+		// the webhook matters most
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), webhookTimeout)
+		defer cancel()
+		if err := b.webhook(ctx, data); err != nil {
+			b.error(fmt.Errorf("error sending webhook: %w", err))
+		}
+	}
+}
+
+// error passes err to OnError, if it's set.
+func (b *batchHooks) error(err error) {
+	if b.hooks.OnError != nil {
+		b.hooks.OnError(err)
+	}
+}
+
+// webhook POSTs the summary, retrying on network errors, rate limits and
+// server errors.
+func (b *batchHooks) webhook(ctx context.Context, data []byte) error {
+	transport, err := b.d.transport()
+	if err != nil {
+		return err
+	}
+	client := http.Client{Transport: transport}
+
+	retrier := &Downloader{Retries: max(cmp.Or(b.hooks.WebhookRetries, defaultWebhookRetries), 0)}
+	return retrier.retry(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.hooks.Webhook, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		return statusError(b.hooks.Webhook, res.StatusCode)
+	})
+}
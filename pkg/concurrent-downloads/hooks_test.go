@@ -0,0 +1,186 @@
+package concurrentdownloads_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestHooks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	urls := []string{srv.URL + "/a", srv.URL + "/missing", srv.URL + "/b"}
+
+	t.Run("it calls back for each item and the batch", func(t *testing.T) {
+		mu := sync.Mutex{}
+		items := []ItemSummary{}
+		batches := []BatchSummary{}
+		d := &Downloader{ErrorPolicy: CollectErrors, Hooks: &Hooks{
+			OnItem: func(item ItemSummary) {
+				mu.Lock()
+				defer mu.Unlock()
+				items = append(items, item)
+			},
+			OnBatch: func(batch BatchSummary) {
+				batches = append(batches, batch)
+			},
+		}}
+		d.DownloadAll(t.Context(), urls)
+
+		if len(items) != 3 || len(batches) != 1 {
+			t.Fatalf("expected 3 items and 1 batch, got %v and %v", len(items), len(batches))
+		}
+		batch := batches[0]
+		if batch.Items != 3 || batch.Succeeded != 2 || batch.Failed != 1 || batch.Failures[0].URL != srv.URL+"/missing" || batch.Err == nil {
+			t.Errorf("unexpected summary %+v", batch)
+		}
+	})
+
+	t.Run("it posts the summary to a webhook with retries", func(t *testing.T) {
+		attempts := atomic.Int64{}
+		received := make(chan BatchSummary, 1)
+		hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if attempts.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("unexpected content type %q", r.Header.Get("Content-Type"))
+			}
+			body, _ := io.ReadAll(r.Body)
+			summary := BatchSummary{}
+			if err := json.Unmarshal(body, &summary); err != nil {
+				t.Error(err)
+			}
+			received <- summary
+		}))
+		defer hook.Close()
+
+		d := &Downloader{Hooks: &Hooks{Webhook: hook.URL}}
+		if _, err := d.DownloadAll(t.Context(), urls[:1]); err != nil {
+			t.Fatal(err)
+		}
+		summary := <-received
+		if summary.Items != 1 || summary.Succeeded != 1 || attempts.Load() != 2 {
+			t.Errorf("unexpected summary %+v after %v attempts", summary, attempts.Load())
+		}
+	})
+
+	t.Run("it reports webhook failures apart from the batch", func(t *testing.T) {
+		hook := httptest.NewServer(http.NotFoundHandler())
+		defer hook.Close()
+
+		errs := []error{}
+		d := &Downloader{Hooks: &Hooks{
+			Webhook:        hook.URL,
+			WebhookRetries: -1,
+			SummaryFile:    filepath.Join(t.TempDir(), "missing", "summary.json"),
+			OnError:        func(err error) { errs = append(errs, err) },
+		}}
+		if _, err := d.DownloadAll(t.Context(), urls[:1]); err != nil {
+			t.Errorf("expected the batch to succeed, got %v", err)
+		}
+		var status *StatusError
+		if len(errs) != 2 || !errors.Is(errs[0], os.ErrNotExist) || !errors.As(errs[1], &status) {
+			t.Errorf("expected summary and webhook errors, got %v", errs)
+		}
+	})
+
+	t.Run("it tracks every kind of batch", func(t *testing.T) {
+		manifest := &Manifest{File: "test", Entries: []ManifestEntry{{URL: urls[0]}, {URL: urls[2]}}}
+		if err := manifest.Validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, test := range []struct {
+			name string
+			run  func(d *Downloader)
+			// expected is the items, succeeded and failed
+			expected [3]int
+		}{
+			{"manifest", func(d *Downloader) {
+				d.RunManifest(t.Context(), manifest, t.TempDir())
+			}, [3]int{2, 2, 0}},
+			{"batch", func(d *Downloader) {
+				b := d.Start(t.Context())
+				for _, h := range []*Handle{b.Submit(Item{URL: urls[0]}), b.Submit(Item{URL: urls[1]})} {
+					h.Wait(t.Context())
+				}
+				b.Close()
+			}, [3]int{2, 1, 1}},
+			{"crawl", func(d *Downloader) {
+				(&Crawler{Downloader: d, IgnoreRobots: true}).Crawl(t.Context(), urls[:1])
+			}, [3]int{1, 1, 0}},
+			{"link check", func(d *Downloader) {
+				(&LinkChecker{Downloader: d}).Check(t.Context(), urls)
+			}, [3]int{3, 2, 1}},
+			{"pipeline", func(d *Downloader) {
+				p := NewPipeline(t.Context(), d, []Item{{URL: urls[0]}, {URL: urls[2]}}, 1)
+				p.Sink(1, func(ctx context.Context, r *Result) error { return nil })
+			}, [3]int{2, 2, 0}},
+		} {
+			t.Run(test.name, func(t *testing.T) {
+				batches := []BatchSummary{}
+				d := &Downloader{Hooks: &Hooks{OnBatch: func(b BatchSummary) { batches = append(batches, b) }}}
+				test.run(d)
+				if len(batches) != 1 {
+					t.Fatalf("expected 1 batch, got %v", len(batches))
+				}
+				if got := [3]int{batches[0].Items, batches[0].Succeeded, batches[0].Failed}; got != test.expected {
+					t.Errorf("expected %v, got %v", test.expected, got)
+				}
+			})
+		}
+
+		t.Run("load test", func(t *testing.T) {
+			batches := []BatchSummary{}
+			d := &Downloader{Concurrency: 1, Hooks: &Hooks{OnBatch: func(b BatchSummary) { batches = append(batches, b) }}}
+			test := &LoadTest{Downloader: d, Targets: urls[:1], Rate: 100, Duration: 50 * time.Millisecond}
+			report, err := test.Run(t.Context())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(batches) != 1 {
+				t.Fatalf("expected 1 batch, got %v", len(batches))
+			}
+			if b := batches[0]; int64(b.Succeeded) != report.Requests || int64(b.Skipped) != report.Dropped || b.Items == 0 {
+				t.Errorf("unexpected summary %+v for %+v", b, report)
+			}
+		})
+	})
+
+	t.Run("it writes the summary to a file", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "summary.json")
+		d := &Downloader{Hooks: &Hooks{SummaryFile: name}}
+		d.DownloadAll(t.Context(), urls)
+
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		summary := BatchSummary{}
+		if err := json.Unmarshal(data, &summary); err != nil {
+			t.Fatal(err)
+		}
+		if summary.Items != 3 || summary.Error == "" {
+			t.Errorf("unexpected summary %+v", summary)
+		}
+	})
+}
@@ -0,0 +1,234 @@
+package concurrentdownloads
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// JobState is the state of an item recorded in a [Journal].
+type JobState string
+
+const (
+	StateQueued   JobState = "queued"
+	StateInFlight JobState = "in-flight"
+	StateDone     JobState = "done"
+	StateFailed   JobState = "failed"
+)
+
+// compactMinRecords is how many records a journal can hold before it's worth
+// compacting.
+const compactMinRecords = 1024
+
+// JournalRecord is a single line of a journal.
+type JournalRecord struct {
+	URL   string   `json:"url"`
+	State JobState `json:"state"`
+	// Digest is the "sha256:hex" of the download once it's done.
+	Digest string `json:"digest,omitempty"`
+	// ETag is the validator of a partial download, so it's only resumed if
+	// the remote file hasn't changed.
+	ETag  string `json:"etag,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Journal is an append-only log of the state of each item in a batch, so an
+// interrupted batch can be resumed. Every change is appended as a JSON line,
+// and the last record for a URL wins.
+//
+// The journal compacts itself down to one record per URL when it's opened,
+// and whenever superseded records outnumber the live ones. A nil *Journal
+// records nothing.
+type Journal struct {
+	mu      sync.Mutex
+	name    string
+	file    *os.File
+	state   map[string]JournalRecord
+	records int
+}
+
+// OpenJournal opens or creates the journal at name, and loads its state.
+func OpenJournal(name string) (*Journal, error) {
+	j := &Journal{name: name, state: map[string]JournalRecord{}}
+
+	file, err := os.Open(name)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		err := j.load(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// load reads the records in r.
+func (j *Journal) load(r io.Reader) error {
+	return readJSONLines(r, func(record JournalRecord) {
+		if record.URL == "" {
+			return
+		}
+		j.state[record.URL] = record
+		j.records++
+	})
+}
+
+// Lookup returns the latest record for url.
+func (j *Journal) Lookup(url string) (JournalRecord, bool) {
+	if j == nil {
+		return JournalRecord{}, false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	record, ok := j.state[url]
+	return record, ok
+}
+
+// Record appends a record to the journal.
+func (j *Journal) Record(record JournalRecord) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return os.ErrClosed
+	}
+	if err := appendJSONLine(j.file, record); err != nil {
+		return err
+	}
+	j.state[record.URL] = record
+	j.records++
+
+	if j.records > compactMinRecords && j.records > 2*len(j.state) {
+		return j.compact()
+	}
+	return nil
+}
+
+// Compact rewrites the journal with only the latest record for each URL.
+func (j *Journal) Compact() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.compact()
+}
+
+// compact replaces the journal file atomically, so a crash while compacting
+// leaves either the old or the new journal in place. The caller holds j.mu.
+func (j *Journal) compact() error {
+	err := replaceFile(j.name, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		for _, record := range j.state {
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file, err = os.OpenFile(j.name, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	j.records = len(j.state)
+	return nil
+}
+
+// Close syncs and closes the journal file.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := errors.Join(j.file.Sync(), j.file.Close())
+	j.file = nil
+	return err
+}
+
+// appendJSONLine writes v to w as a JSON line, in a single write of the whole
+// line, so concurrent appends never interleave and a crash tears at most the
+// last line.
+func appendJSONLine(w io.Writer, v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// readJSONLines calls fn with each JSON line read from r, skipping lines which
+// don't decode. A process killed mid-write can leave a torn last line, which
+// is ignored.
+func readJSONLines[T any](r io.Reader, fn func(T)) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var v T
+		if err := json.Unmarshal(line, &v); err != nil {
+			continue
+		}
+		fn(v)
+	}
+}
+
+// replaceFile replaces name with what write writes through a temporary file,
+// which is synced before it's renamed into place, so a crash leaves either
+// the old or the new file.
+func replaceFile(name string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := write(w); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
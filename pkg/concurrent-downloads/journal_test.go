@@ -0,0 +1,141 @@
+package concurrentdownloads_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestJournal(t *testing.T) {
+	t.Run("it keeps the latest state", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "journal")
+		j, err := OpenJournal(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j.Record(JournalRecord{URL: "a", State: StateQueued})
+		j.Record(JournalRecord{URL: "b", State: StateQueued})
+		j.Record(JournalRecord{URL: "a", State: StateDone, Digest: "sha256:abc"})
+		if err := j.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Simulate a crash halfway through writing a record
+		f, _ := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0o644)
+		f.WriteString(`{"url":"b","sta`)
+		f.Close()
+
+		j, err = OpenJournal(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer j.Close()
+
+		if record, _ := j.Lookup("a"); record.State != StateDone || record.Digest != "sha256:abc" {
+			t.Errorf("unexpected record: %+v", record)
+		}
+		if record, _ := j.Lookup("b"); record.State != StateQueued {
+			t.Errorf("unexpected record: %+v", record)
+		}
+	})
+
+	t.Run("it compacts itself", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "journal")
+		j, err := OpenJournal(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer j.Close()
+
+		for range 5000 {
+			for _, state := range []JobState{StateQueued, StateInFlight, StateDone} {
+				if err := j.Record(JournalRecord{URL: "a", State: state}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		data, _ := os.ReadFile(name)
+		if lines := bytes.Count(data, []byte("\n")); lines > 2048 {
+			t.Errorf("expected the journal to be compacted, got %v lines", lines)
+		}
+	})
+}
+
+func TestRunManifestJournal(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+
+	mu := sync.Mutex{}
+	requests := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, strings.TrimSpace(r.URL.Path+" "+r.Header.Get("Range")+" "+r.Header.Get("If-Range")))
+		mu.Unlock()
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	manifest := func() *Manifest {
+		m := &Manifest{File: "test", Entries: []ManifestEntry{
+			{URL: srv.URL + "/done.txt"},
+			{URL: srv.URL + "/partial.txt"},
+			{URL: srv.URL + "/new.txt"},
+		}}
+		if err := m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "journal")
+	j, err := OpenJournal(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	// A previous run finished one item, and was killed halfway through another
+	os.WriteFile(filepath.Join(dir, "done.txt"), []byte(content), 0o644)
+	j.Record(JournalRecord{URL: srv.URL + "/done.txt", State: StateDone, Digest: "sha256:x"})
+	os.WriteFile(filepath.Join(dir, "partial.txt.part"), []byte(content[:400]), 0o644)
+	j.Record(JournalRecord{URL: srv.URL + "/partial.txt", State: StateInFlight, ETag: `"v1"`})
+
+	d := &Downloader{Journal: j}
+	if err := d.RunManifest(t.Context(), manifest(), dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{"done.txt", "partial.txt", "new.txt"} {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil || string(data) != content {
+			t.Errorf("unexpected content for %v: %v", file, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "partial.txt.part")); !os.IsNotExist(err) {
+		t.Errorf("expected the part file to be gone, got %v", err)
+	}
+
+	mu.Lock()
+	got := strings.Join(requests, ",")
+	mu.Unlock()
+	if strings.Contains(got, "/done.txt") || !strings.Contains(got, `/partial.txt bytes=400- "v1"`) {
+		t.Errorf("unexpected requests: %v", got)
+	}
+
+	for _, file := range []string{"partial.txt", "new.txt"} {
+		record, _ := j.Lookup(srv.URL + "/" + file)
+		if record.State != StateDone || !strings.HasPrefix(record.Digest, "sha256:") {
+			t.Errorf("unexpected record for %v: %+v", file, record)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package concurrentdownloads
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// errTrailingData is the error for a body with more after its JSON value.
+var errTrailingData = errors.New("unexpected data after JSON value")
+
+// DecodeError is returned when a response can't be decoded, or the decoded
+// value fails validation.
+type DecodeError struct {
+	URL string
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("error decoding %s: %v", e.URL, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// DownloadAllJSON returns a map of {url:value}, decoding each response body
+// into a T as it's streamed. Responses must have a JSON Content-Type. When
+// validate is set, it's called with each decoded value, and an error from it
+// fails that URL.
+//
+// Errors are handled according to the Downloader's ErrorPolicy, with decode
+// and validation errors returned as a [*DecodeError]. A nil Downloader
+// behaves like the zero value.
+func DownloadAllJSON[T any](ctx context.Context, d *Downloader, urls []string, validate func(T) error) (map[string]T, error) {
+	if d == nil {
+		d = &Downloader{}
+	}
+	items := make([]Item, len(urls))
+	for i, url := range urls {
+		items[i] = Item{URL: url}
+	}
+
+	values := make(map[string]T, len(urls))
+	mu := sync.Mutex{}
+
+	err := d.each(ctx, items, func(ctx context.Context, item Item) error {
+		var value T
+		err := d.retry(ctx, func() error {
+			value = *new(T)
+			return d.decodeJSON(ctx, item, &value)
+		})
+		if err == nil && validate != nil {
+			if err := validate(value); err != nil {
+				return &DecodeError{URL: item.URL, Err: err}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		values[item.URL] = value
+		mu.Unlock()
+		return nil
+	})
+	return values, err
+}
+
+// decodeJSON makes a single request for item, and decodes the body into v.
+func (d *Downloader) decodeJSON(ctx context.Context, item Item, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, item.URL, nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range item.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, _, err := d.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err := d.checkStatus(item.URL, res.StatusCode); err != nil {
+		return err
+	}
+	if !isJSON(res.Header.Get("Content-Type")) {
+		return &DecodeError{URL: item.URL, Err: fmt.Errorf("unexpected Content-Type %q", res.Header.Get("Content-Type"))}
+	}
+
+	dec := json.NewDecoder(res.Body)
+	if err := dec.Decode(v); err != nil {
+		return decodeError(item.URL, err)
+	}
+	// Only whitespace may follow the value
+	if _, err := dec.Token(); err != io.EOF {
+		return decodeError(item.URL, cmp.Or(err, errTrailingData))
+	}
+	return nil
+}
+
+// decodeError wraps an error from decoding a body, unless the body couldn't
+// be read, which is a network error worth retrying.
+func decodeError(url string, err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return err
+	}
+	return &DecodeError{URL: url, Err: err}
+}
+
+// isJSON reports whether a Content-Type is JSON, including types like
+// application/problem+json.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,75 @@
+package concurrentdownloads_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestDownloadAllJSON(t *testing.T) {
+	type Package struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+
+	mux := http.NewServeMux()
+	serve := func(path, contentType, body string) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte(body))
+		})
+	}
+	serve("/a", "application/json", `{"name": "a", "version": "1.0"}`)
+	serve("/b", "application/vnd.api+json; charset=utf-8", `{"name": "b", "version": "2.0"}`)
+	serve("/html", "text/html", `<html></html>`)
+	serve("/broken", "application/json", `{"name": `)
+	serve("/wrong", "application/json", `{"name": 1}`)
+	serve("/trailing", "application/json", `{"name": "c"} {}`)
+	serve("/unversioned", "application/json", `{"name": "d"}`)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	t.Run("it decodes each response", func(t *testing.T) {
+		values, err := DownloadAllJSON[Package](t.Context(), nil, []string{srv.URL + "/a", srv.URL + "/b"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if values[srv.URL+"/a"] != (Package{"a", "1.0"}) || values[srv.URL+"/b"] != (Package{"b", "2.0"}) {
+			t.Errorf("unexpected values %v", values)
+		}
+	})
+
+	t.Run("it returns decode errors per URL", func(t *testing.T) {
+		for _, path := range []string{"/html", "/broken", "/wrong", "/trailing"} {
+			d := &Downloader{Retries: 2}
+			_, err := DownloadAllJSON[Package](t.Context(), d, []string{srv.URL + path}, nil)
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) || decodeErr.URL != srv.URL+path {
+				t.Errorf("expected a decode error for %v, got %v", path, err)
+			}
+		}
+	})
+
+	t.Run("it validates values", func(t *testing.T) {
+		validate := func(p Package) error {
+			if p.Version == "" {
+				return errors.New("missing version")
+			}
+			return nil
+		}
+		d := &Downloader{ErrorPolicy: CollectErrors}
+		values, err := DownloadAllJSON(t.Context(), d, []string{srv.URL + "/a", srv.URL + "/unversioned", srv.URL + "/missing"}, validate)
+
+		var decodeErr *DecodeError
+		var status *StatusError
+		if !errors.As(err, &decodeErr) || decodeErr.URL != srv.URL+"/unversioned" || !errors.As(err, &status) {
+			t.Errorf("expected a validation and a status error, got %v", err)
+		}
+		if len(values) != 1 || values[srv.URL+"/a"].Name != "a" {
+			t.Errorf("unexpected values %v", values)
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package concurrentdownloads
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/lipgloss/v2/table"
+)
+
+// LinkResult is the outcome of checking a single link.
+type LinkResult struct {
+	URL string
+	// StatusCode is zero when the request failed.
+	StatusCode int
+	Err        error
+}
+
+// Broken reports whether the link failed to resolve.
+func (r LinkResult) Broken() bool {
+	return r.Err != nil || r.StatusCode > 399
+}
+
+// Status returns the status line the result is grouped by in reports.
+func (r LinkResult) Status() string {
+	if r.Err != nil {
+		return "error"
+	}
+	return fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode))
+}
+
+// LinkChecker checks that links resolve, using HEAD requests and falling back
+// to GET when the server doesn't allow HEAD.
+type LinkChecker struct {
+	// Downloader provides the worker pool and its concurrency limit.
+	Downloader *Downloader
+}
+
+// Check returns the result for each of urls, in the same order.
+func (c *LinkChecker) Check(ctx context.Context, urls []string) []LinkResult {
+	d := c.Downloader
+	if d == nil {
+		d = &Downloader{}
+	}
+
+	items := []Item{}
+	results := map[string]LinkResult{}
+	for _, url := range urls {
+		if _, ok := results[url]; !ok {
+			results[url] = LinkResult{URL: url}
+			items = append(items, Item{URL: url})
+		}
+	}
+	mu := sync.Mutex{}
+	batch := d.Hooks.startBatch(d, len(items))
+
+	q := newQueue(d.Aging)
+	q.push(items...)
+	q.close()
+	d.run(ctx, q, d.workers(len(items)), func(item Item) {
+		start := time.Now()
+		result := d.checkLink(ctx, item.URL)
+		err := result.Err
+		if err == nil {
+			err = statusError(item.URL, result.StatusCode)
+		}
+		batch.item(item.URL, start, err)
+		mu.Lock()
+		results[item.URL] = result
+		mu.Unlock()
+	})
+
+	checked := make([]LinkResult, len(urls))
+	for i, url := range urls {
+		checked[i] = results[url]
+		// Anything left unchecked was canceled before it started
+		if checked[i].StatusCode == 0 && checked[i].Err == nil {
+			checked[i].Err = context.Cause(ctx)
+		}
+	}
+	batch.finish(ctx, context.Cause(ctx))
+	return checked
+}
+
+// checkLink requests url with HEAD, and falls back to GET when the server
+// doesn't support it.
+func (d *Downloader) checkLink(ctx context.Context, url string) LinkResult {
+	result := LinkResult{URL: url}
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+
+		res, _, err := d.do(req)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		// We only care about the status, so don't read the body
+		res.Body.Close()
+
+		result.StatusCode = res.StatusCode
+		if res.StatusCode != http.StatusMethodNotAllowed && res.StatusCode != http.StatusNotImplemented {
+			break
+		}
+	}
+	return result
+}
+
+// markdownLinkPattern matches inline links and images, [text](url "title"),
+// and autolinks, <url>.
+var markdownLinkPattern = regexp.MustCompile(`\]\(\s*<?([^)\s>]+)>?(?:\s+["'(][^)]*)?\)|<(https?://[^>\s]+)>`)
+
+// bareLinkPattern matches URLs written out in plain text.
+var bareLinkPattern = regexp.MustCompile(`https?://[^\s<>"'()\[\]]+`)
+
+// ExtractFileLinks returns the unique absolute http(s) links in a Markdown or
+// HTML file, in the order they first appear. The format is chosen by the
+// file's extension, and anything that isn't HTML is treated as Markdown.
+func ExtractFileLinks(name string, content []byte) []string {
+	var links []string
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".html", ".htm", ".xhtml":
+		links = extractLinks(&url.URL{}, content)
+	default:
+		for _, match := range markdownLinkPattern.FindAllSubmatch(content, -1) {
+			links = append(links, string(match[1])+string(match[2]))
+		}
+		for _, match := range bareLinkPattern.FindAll(content, -1) {
+			// Trailing punctuation is almost always part of the sentence
+			links = append(links, strings.TrimRight(string(match), ".,;:!?"))
+		}
+	}
+
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, link := range links {
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			continue
+		}
+		if !seen[link] {
+			seen[link] = true
+			unique = append(unique, link)
+		}
+	}
+	return unique
+}
+
+// FormatLinkReport outputs link results grouped by status in a nicely
+// formatted table using `lipgloss`, with broken links first.
+func FormatLinkReport(results []LinkResult) string {
+	sorted := slices.Clone(results)
+	slices.SortStableFunc(sorted, func(a, b LinkResult) int {
+		if a.Broken() != b.Broken() {
+			if a.Broken() {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(
+			cmp.Compare(a.Status(), b.Status()),
+			cmp.Compare(a.URL, b.URL),
+		)
+	})
+
+	table := table.New().
+		Headers("Status", "URL", "Error").
+		Rows(func() [][]string {
+			rows := make([][]string, len(sorted))
+			for i, result := range sorted {
+				errText := ""
+				if result.Err != nil {
+					errText = result.Err.Error()
+				}
+				rows[i] = []string{result.Status(), result.URL, errText}
+			}
+			return rows
+		}()...)
+	return table.Render()
+}
@@ -0,0 +1,105 @@
+package concurrentdownloads_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestLinkChecker(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/no-head", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	t.Run("it checks links", func(t *testing.T) {
+		urls := []string{srv.URL + "/ok", srv.URL + "/no-head", srv.URL + "/missing", srv.URL + "/ok"}
+		results := (&LinkChecker{}).Check(t.Context(), urls)
+
+		expected := []int{200, 200, 404, 200}
+		for i, result := range results {
+			if result.URL != urls[i] || result.StatusCode != expected[i] {
+				t.Errorf("expected %v %v, got %v %v", urls[i], expected[i], result.URL, result.StatusCode)
+			}
+		}
+		if !results[2].Broken() || results[1].Broken() {
+			t.Errorf("unexpected broken links: %v", results)
+		}
+	})
+
+	t.Run("it reports connection errors", func(t *testing.T) {
+		closed := httptest.NewServer(mux)
+		closed.Close()
+
+		results := (&LinkChecker{}).Check(t.Context(), []string{closed.URL + "/ok"})
+		if results[0].Err == nil || !results[0].Broken() {
+			t.Errorf("expected an error, got %v", results[0])
+		}
+	})
+
+	t.Run("it formats a report", func(t *testing.T) {
+		results := []LinkResult{
+			{URL: "https://example.com/a", StatusCode: 200},
+			{URL: "https://example.com/b", StatusCode: 404},
+		}
+
+		expected := `╭─────────────┬─────────────────────┬─────╮
+│Status       │URL                  │Error│
+├─────────────┼─────────────────────┼─────┤
+│404 Not Found│https://example.com/b│     │
+│200 OK       │https://example.com/a│     │
+╰─────────────┴─────────────────────┴─────╯`
+
+		if actual := FormatLinkReport(results); actual != expected {
+			t.Errorf("Expected %v, got %v", expected, actual)
+		}
+	})
+}
+
+func TestExtractFileLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		expected []string
+	}{
+		{
+			name:    "markdown",
+			file:    "README.md",
+			content: "See [docs](https://example.com/docs \"Docs\") and <https://example.com/auto>.\n![img](https://example.com/a.png) [local](./other.md)\nAlso https://example.com/bare, and [docs](https://example.com/docs) again.",
+			expected: []string{
+				"https://example.com/docs",
+				"https://example.com/auto",
+				"https://example.com/a.png",
+				"https://example.com/bare",
+			},
+		},
+		{
+			name:    "html",
+			file:    "index.html",
+			content: `<a href="https://example.com/one">one</a> <img src='https://example.com/two.png'> <a href="/relative">x</a> <a href="mailto:me@example.com">`,
+			expected: []string{
+				"https://example.com/one",
+				"https://example.com/two.png",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := ExtractFileLinks(tt.file, []byte(tt.content))
+			if !slices.Equal(actual, tt.expected) {
+				t.Errorf("Expected %v, got %v", strings.Join(tt.expected, " "), strings.Join(actual, " "))
+			}
+		})
+	}
+}
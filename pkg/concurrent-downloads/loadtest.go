@@ -0,0 +1,301 @@
+package concurrentdownloads
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"math/bits"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/lipgloss/v2/table"
+)
+
+// LoadTest hits a set of URLs for a fixed duration, either at a fixed request
+// rate or as fast as the Downloader's concurrency allows.
+type LoadTest struct {
+	// Downloader provides the worker pool and its concurrency limit. Retries
+	// are not used, each request is made once.
+	Downloader *Downloader
+	// Targets are requested in turn.
+	Targets []string
+	// Rate is the number of requests per second to send. Each request's
+	// latency is timed from when it was due to be sent, so time spent
+	// waiting for a free worker counts against it. When it's zero, each
+	// worker sends its next request as soon as the last one finishes.
+	Rate     float64
+	Duration time.Duration
+}
+
+// LoadReport summarizes a load test.
+type LoadReport struct {
+	Requests int64 `json:"requests"`
+	// Errors counts requests which failed or had an error status.
+	Errors int64 `json:"errors"`
+	// Dropped counts requests which were never sent at a fixed rate,
+	// because every worker was busy and the backlog was full when they were
+	// due, or they were still waiting when the time was up.
+	Dropped    int64         `json:"dropped"`
+	ErrorRate  float64       `json:"error_rate"`
+	Throughput float64       `json:"throughput"`
+	Elapsed    time.Duration `json:"-"`
+	// ElapsedSeconds is Elapsed, for JSON.
+	ElapsedSeconds float64       `json:"elapsed_seconds"`
+	Codes          map[int]int64 `json:"codes"`
+	Latency        Latency       `json:"latency"`
+}
+
+// Latency is a summary of request latencies, in milliseconds.
+type Latency struct {
+	P50  float64 `json:"p50_ms"`
+	P90  float64 `json:"p90_ms"`
+	P99  float64 `json:"p99_ms"`
+	Max  float64 `json:"max_ms"`
+	Mean float64 `json:"mean_ms"`
+}
+
+// Run runs the load test and returns its report. It stops early if ctx is
+// done, reporting on the requests made so far.
+func (l *LoadTest) Run(ctx context.Context) (*LoadReport, error) {
+	if len(l.Targets) == 0 {
+		return nil, fmt.Errorf("load test has no targets")
+	}
+	d := l.Downloader
+	if d == nil {
+		d = &Downloader{}
+	}
+	workers := d.workers(defaultConcurrency)
+
+	mu := sync.Mutex{}
+	hist := &hdrHistogram{}
+	report := &LoadReport{Codes: map[int]int64{}}
+
+	next := 0
+	target := func() Item {
+		mu.Lock()
+		defer mu.Unlock()
+		item := Item{URL: l.Targets[next%len(l.Targets)]}
+		next++
+		return item
+	}
+
+	start := time.Now()
+	deadline := start.Add(l.Duration)
+	// Each request is an item, and dropped ones count as skipped
+	batch := d.Hooks.startBatch(d, 0)
+
+	// hit makes a request, timing it from begin
+	hit := func(item Item, begin time.Time) {
+		batch.add(1)
+		result, err := d.fetchOnce(ctx, item.URL, nil)
+		elapsed := time.Since(begin)
+		if ctx.Err() != nil {
+			// Requests cut short by cancellation would skew the latency
+			return
+		}
+		batch.item(item.URL, begin, err)
+
+		mu.Lock()
+		defer mu.Unlock()
+		report.Requests++
+		if err != nil {
+			report.Errors++
+		}
+		if result != nil {
+			report.Codes[result.Response.StatusCode]++
+		}
+		hist.record(elapsed.Microseconds())
+	}
+
+	if l.Rate > 0 {
+		l.schedule(ctx, workers, start, deadline, target, hit, func() {
+			batch.add(1)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Dropped++
+		})
+	} else {
+		// Each worker keeps one request going until the time is up
+		seeds := make([]Item, workers)
+		for i := range seeds {
+			seeds[i] = target()
+		}
+		d.walk(ctx, seeds, func(item Item, push func(Item)) {
+			hit(item, time.Now())
+			if time.Now().Before(deadline) && ctx.Err() == nil {
+				push(target())
+			}
+		})
+	}
+
+	report.Elapsed = time.Since(start)
+	report.ElapsedSeconds = report.Elapsed.Seconds()
+	if report.Requests > 0 {
+		report.ErrorRate = float64(report.Errors) / float64(report.Requests)
+		report.Throughput = float64(report.Requests) / report.ElapsedSeconds
+		report.Latency = Latency{
+			P50:  hist.percentile(50) / 1000,
+			P90:  hist.percentile(90) / 1000,
+			P99:  hist.percentile(99) / 1000,
+			Max:  float64(hist.max) / 1000,
+			Mean: hist.mean() / 1000,
+		}
+	}
+	batch.finish(ctx, ctx.Err())
+	return report, ctx.Err()
+}
+
+// schedule sends requests at a fixed rate until the deadline, handing each one
+// to a worker with the time it was due. Requests that can't be handed over
+// because the backlog is full, or which are still waiting at the deadline,
+// are dropped rather than queued without limit.
+func (l *LoadTest) schedule(ctx context.Context, workers int, start, deadline time.Time, target func() Item, hit func(Item, time.Time), drop func()) {
+	type request struct {
+		item Item
+		due  time.Time
+	}
+	requests := make(chan request, workers)
+	wg := sync.WaitGroup{}
+	for range workers {
+		wg.Go(func() {
+			for r := range requests {
+				if ctx.Err() != nil || time.Now().After(deadline) {
+					drop()
+					continue
+				}
+				hit(r.item, r.due)
+			}
+		})
+	}
+	defer wg.Wait()
+	defer close(requests)
+
+	// Due times are worked out from the start, so they don't drift
+	interval := time.Duration(float64(time.Second) / l.Rate)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for i := 0; ; i++ {
+		due := start.Add(time.Duration(i) * interval)
+		if !due.Before(deadline) {
+			return
+		}
+		if wait := time.Until(due); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case requests <- request{item: target(), due: due}:
+		default:
+			drop()
+		}
+	}
+}
+
+// JSON returns the report as indented JSON.
+func (r *LoadReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// FormatLoadReport outputs a load test report in a nicely formatted table
+// using `lipgloss`.
+func FormatLoadReport(r *LoadReport) string {
+	ms := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', 2, 64) + "ms"
+	}
+	rows := [][]string{
+		{"Requests", fmt.Sprint(r.Requests)},
+		{"Elapsed", r.Elapsed.Round(time.Millisecond).String()},
+		{"Throughput", strconv.FormatFloat(r.Throughput, 'f', 1, 64) + "/s"},
+		{"Error rate", strconv.FormatFloat(r.ErrorRate*100, 'f', 1, 64) + "%"},
+		{"Dropped", fmt.Sprint(r.Dropped)},
+		{"p50", ms(r.Latency.P50)},
+		{"p90", ms(r.Latency.P90)},
+		{"p99", ms(r.Latency.P99)},
+		{"max", ms(r.Latency.Max)},
+	}
+	for _, code := range slices.Sorted(maps.Keys(r.Codes)) {
+		rows = append(rows, []string{fmt.Sprintf("Status %d", code), fmt.Sprint(r.Codes[code])})
+	}
+
+	table := table.New().
+		Headers("Metric", "Value").
+		Rows(rows...)
+	return table.Render()
+}
+
+// hdrSubBits sets the histogram's precision, 2^8 sub-buckets keeps values
+// within 1% of what was recorded.
+const hdrSubBits = 8
+
+// hdrHistogram is a log-linear histogram in the style of HdrHistogram. Values
+// are grouped into buckets by their highest bit, and each bucket is split
+// linearly into sub-buckets, so the relative error is the same at every
+// magnitude.
+type hdrHistogram struct {
+	counts []int64
+	total  int64
+	sum    float64
+	max    int64
+}
+
+func (h *hdrHistogram) record(v int64) {
+	v = max(v, 0)
+	i := hdrIndex(v)
+	if i >= len(h.counts) {
+		h.counts = append(h.counts, make([]int64, i-len(h.counts)+1)...)
+	}
+	h.counts[i]++
+	h.total++
+	h.sum += float64(v)
+	h.max = max(h.max, v)
+}
+
+// percentile returns the value at or below which p percent of the recorded
+// values fall.
+func (h *hdrHistogram) percentile(p float64) float64 {
+	if h.total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p / 100 * float64(h.total)))
+	seen := int64(0)
+	for i, count := range h.counts {
+		seen += count
+		if seen >= max(rank, 1) {
+			return float64(min(hdrHighest(i), h.max))
+		}
+	}
+	return float64(h.max)
+}
+
+func (h *hdrHistogram) mean() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / float64(h.total)
+}
+
+// hdrIndex returns the counts index for v.
+func hdrIndex(v int64) int {
+	shift := max(bits.Len64(uint64(v))-hdrSubBits, 0)
+	return shift<<(hdrSubBits-1) + int(v>>shift)
+}
+
+// hdrHighest returns the highest value which is counted at index i.
+func hdrHighest(i int) int64 {
+	const subBuckets = 1 << hdrSubBits
+	const half = subBuckets / 2
+	if i < subBuckets {
+		return int64(i)
+	}
+	shift := (i-subBuckets)/half + 1
+	sub := int64((i-subBuckets)%half + half)
+	return (sub+1)<<shift - 1
+}
@@ -0,0 +1,126 @@
+package concurrentdownloads_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+	"github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads/downloadtest"
+)
+
+func TestLoadTest(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	t.Run("it runs at a fixed rate", func(t *testing.T) {
+		test := &LoadTest{
+			Downloader: &Downloader{Concurrency: 2},
+			Targets:    []string{srv.URL + "/ok", srv.URL + "/missing"},
+			Rate:       100,
+			Duration:   200 * time.Millisecond,
+		}
+		report, err := test.Run(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// About 20 requests, allowing for a slow machine
+		if report.Requests < 10 || report.Requests > 30 {
+			t.Errorf("expected about 20 requests, got %v", report.Requests)
+		}
+		if report.Codes[200]+report.Codes[404] != report.Requests || report.Errors != report.Codes[404] {
+			t.Errorf("unexpected codes %v for %v requests, %v errors", report.Codes, report.Requests, report.Errors)
+		}
+		if report.ErrorRate < 0.4 || report.ErrorRate > 0.6 {
+			t.Errorf("expected half the requests to fail, got %v", report.ErrorRate)
+		}
+		latency := report.Latency
+		if latency.P50 <= 0 || latency.P50 > latency.P90 || latency.P90 > latency.P99 || latency.P99 > latency.Max {
+			t.Errorf("unexpected latency %+v", latency)
+		}
+	})
+
+	t.Run("it drops requests it can't keep up with", func(t *testing.T) {
+		slow := downloadtest.NewServer(t)
+		slow.Handle("/slow", downloadtest.Response{Latency: 50 * time.Millisecond})
+
+		test := &LoadTest{
+			Downloader: &Downloader{Concurrency: 1},
+			Targets:    []string{slow.URL("/slow")},
+			Rate:       100,
+			Duration:   200 * time.Millisecond,
+		}
+		report, err := test.Run(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Requests are timed from when they were due, so time spent waiting
+		// behind slow ones counts
+		if report.Dropped == 0 || report.Requests+report.Dropped < 15 {
+			t.Errorf("expected dropped requests, got %+v", report)
+		}
+		if report.Latency.P90 < 90 {
+			t.Errorf("expected latency to include waiting for a worker, got %+v", report.Latency)
+		}
+		if report.Elapsed > 350*time.Millisecond {
+			t.Errorf("expected to stop soon after the duration, took %v", report.Elapsed)
+		}
+	})
+
+	t.Run("it runs at a fixed concurrency", func(t *testing.T) {
+		test := &LoadTest{
+			Downloader: &Downloader{Concurrency: 4},
+			Targets:    []string{srv.URL + "/ok"},
+			Duration:   100 * time.Millisecond,
+		}
+		report, err := test.Run(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Requests < 4 || report.Errors != 0 || report.Throughput <= 0 {
+			t.Errorf("unexpected report %+v", report)
+		}
+	})
+
+	t.Run("it renders the report", func(t *testing.T) {
+		report := &LoadReport{
+			Requests: 10,
+			Errors:   1,
+			Codes:    map[int]int64{200: 9, 500: 1},
+			Latency:  Latency{P50: 1.5, P90: 2, P99: 3, Max: 4},
+		}
+		out := FormatLoadReport(report)
+		for _, s := range []string{"Requests", "p99", "3.00ms", "Status 500"} {
+			if !strings.Contains(out, s) {
+				t.Errorf("expected %q in:\n%s", s, out)
+			}
+		}
+
+		data, err := report.JSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := map[string]any{}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded["latency"].(map[string]any)["p99_ms"] != 3.0 {
+			t.Errorf("unexpected JSON %s", data)
+		}
+	})
+
+	t.Run("it needs targets", func(t *testing.T) {
+		if _, err := (&LoadTest{}).Run(t.Context()); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
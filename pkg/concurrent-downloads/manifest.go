@@ -0,0 +1,494 @@
+package concurrentdownloads
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestEntry is a single download listed in a manifest.
+type ManifestEntry struct {
+	URL string `json:"url" yaml:"url"`
+	// Path is where the download is written, relative to the output
+	// directory. It defaults to the last segment of the URL's path.
+	Path string `json:"path,omitempty" yaml:"path"`
+	// Checksum is optional, written as "algorithm:hex" where the algorithm
+	// is one of sha256, sha512 or sha1.
+	Checksum string            `json:"checksum,omitempty" yaml:"checksum"`
+	Headers  map[string]string `json:"headers,omitempty" yaml:"headers"`
+	Priority int               `json:"priority,omitempty" yaml:"priority"`
+	// Line is where the entry starts in the manifest file.
+	Line int `json:"-" yaml:"-"`
+}
+
+// Manifest is a list of downloads loaded from a file.
+type Manifest struct {
+	File    string
+	Entries []ManifestEntry
+}
+
+// ManifestError describes a problem at a line of a manifest file.
+type ManifestError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *ManifestError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *ManifestError) Unwrap() error {
+	return e.Err
+}
+
+// ChecksumError is returned when a download doesn't match its checksum.
+type ChecksumError struct {
+	URL      string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: expected %s, got %s", e.URL, e.Expected, e.Actual)
+}
+
+// LoadManifest reads and validates a manifest file. The format is chosen by
+// the file's extension: .json, .yaml or .yml, and anything else is plain text
+// with one URL per line.
+//
+// Every problem found is returned as a [ManifestError], joined together.
+func LoadManifest(name string) (*Manifest, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return ParseManifest(name, data)
+}
+
+// ParseManifest parses and validates manifest data, using name to choose the
+// format like [LoadManifest] and to report errors.
+func ParseManifest(name string, data []byte) (*Manifest, error) {
+	var entries []ManifestEntry
+	var err error
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		entries, err = parseManifestJSON(name, data)
+	case ".yaml", ".yml":
+		entries, err = parseManifestYAML(name, data)
+	default:
+		entries, err = parseManifestText(name, data)
+	}
+	if err != nil {
+		// Parsing stops at the first error, but join it so callers can
+		// handle every manifest error the same way
+		return nil, errors.Join(err)
+	}
+
+	m := &Manifest{File: name, Entries: entries}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate checks every entry, filling in default paths, and returns all the
+// problems found joined together.
+func (m *Manifest) Validate() error {
+	errs := []error{}
+	fail := func(line int, format string, args ...any) {
+		errs = append(errs, &ManifestError{File: m.File, Line: line, Err: fmt.Errorf(format, args...)})
+	}
+
+	paths := map[string]int{}
+	for i := range m.Entries {
+		entry := &m.Entries[i]
+
+		u, err := url.Parse(entry.URL)
+		if entry.URL == "" {
+			fail(entry.Line, "missing url")
+			continue
+		} else if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail(entry.Line, "invalid url %q", entry.URL)
+			continue
+		}
+
+		if entry.Path == "" {
+			entry.Path = path.Base(u.Path)
+			if entry.Path == "/" || entry.Path == "." {
+				entry.Path = "index.html"
+			}
+		}
+		clean := filepath.Clean(filepath.FromSlash(entry.Path))
+		if filepath.IsAbs(clean) || !filepath.IsLocal(clean) {
+			fail(entry.Line, "path %q must be relative, inside the output directory", entry.Path)
+		} else if line, ok := paths[clean]; ok {
+			fail(entry.Line, "path %q is already used on line %d", entry.Path, line)
+		} else {
+			paths[clean] = entry.Line
+		}
+
+		if entry.Checksum != "" {
+			if _, _, err := parseChecksum(entry.Checksum); err != nil {
+				fail(entry.Line, "%v", err)
+			}
+		}
+
+		for key := range entry.Headers {
+			if key == "" || strings.ContainsAny(key, " \t:\r\n") {
+				fail(entry.Line, "invalid header name %q", key)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Items returns the manifest entries as items to download.
+func (m *Manifest) Items() []Item {
+	items := make([]Item, len(m.Entries))
+	for i, entry := range m.Entries {
+		items[i] = Item{URL: entry.URL, Priority: entry.Priority}
+		if len(entry.Headers) > 0 {
+			items[i].Header = http.Header{}
+			for key, value := range entry.Headers {
+				items[i].Header.Set(key, value)
+			}
+		}
+	}
+	return items
+}
+
+// RunManifest downloads every entry in the manifest into dir, verifying
+// checksums before anything is written. Errors are handled according to the
+// ErrorPolicy.
+//
+// When the Downloader has a Journal, items it records as done are skipped if
+// their files still exist, and partial downloads are resumed.
+func (d *Downloader) RunManifest(ctx context.Context, m *Manifest, dir string) error {
+	items := m.Items()
+	entries := make(map[string][]ManifestEntry, len(items))
+	unique := []Item{}
+	for i, entry := range m.Entries {
+		if _, ok := entries[entry.URL]; !ok {
+			unique = append(unique, items[i])
+		}
+		entries[entry.URL] = append(entries[entry.URL], entry)
+	}
+
+	pending := []Item{}
+	for _, item := range unique {
+		if d.completed(item.URL, dir, entries[item.URL]) {
+			continue
+		}
+		// Keep the validator of a partial download, or it would be resumed
+		// without checking the remote file hasn't changed
+		previous, _ := d.Journal.Lookup(item.URL)
+		if err := d.Journal.Record(JournalRecord{URL: item.URL, State: StateQueued, ETag: previous.ETag}); err != nil {
+			return err
+		}
+		pending = append(pending, item)
+	}
+
+	return d.each(ctx, pending, func(ctx context.Context, item Item) error {
+		digest, err := d.runEntries(ctx, item, dir, entries[item.URL])
+		if err == nil {
+			return d.Journal.Record(JournalRecord{URL: item.URL, State: StateDone, Digest: digest})
+		}
+		if ctx.Err() == nil {
+			// Canceled items stay in-flight, so they're resumed next time.
+			// Failed ones are resumed too, if their part is still there.
+			previous, _ := d.Journal.Lookup(item.URL)
+			err = errors.Join(err, d.Journal.Record(JournalRecord{URL: item.URL, State: StateFailed, ETag: previous.ETag, Error: err.Error()}))
+		}
+		return err
+	})
+}
+
+// completed reports whether the journal has item as done, and its files are
+// still in place.
+func (d *Downloader) completed(url, dir string, entries []ManifestEntry) bool {
+	record, ok := d.Journal.Lookup(url)
+	if !ok || record.State != StateDone {
+		return false
+	}
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(entry.Path))); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// runEntries downloads item once, verifies it against the checksum of each of
+// its entries, then moves it into place at each entry's path. It returns the
+// sha256 digest of the download.
+func (d *Downloader) runEntries(ctx context.Context, item Item, dir string, entries []ManifestEntry) (string, error) {
+	first := filepath.Join(dir, filepath.FromSlash(entries[0].Path))
+	part := first + ".part"
+	if err := d.downloadFile(ctx, item, part); err != nil {
+		return "", err
+	}
+
+	for _, entry := range entries {
+		if err := verifyChecksum(entry, part); err != nil {
+			// The download is complete but wrong, so don't resume it
+			os.Remove(part)
+			return "", err
+		}
+	}
+	sum, err := fileDigest(part, sha256.New)
+	if err != nil {
+		return "", err
+	}
+
+	for _, entry := range entries[1:] {
+		if err := copyFile(part, filepath.Join(dir, filepath.FromSlash(entry.Path))); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(part, first); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(sum), nil
+}
+
+// parseChecksum splits an "algorithm:hex" checksum, and checks the digest is
+// the right length for the algorithm.
+func parseChecksum(checksum string) (func() hash.Hash, []byte, error) {
+	algorithm, digest, ok := strings.Cut(checksum, ":")
+	if !ok {
+		return nil, nil, fmt.Errorf("checksum %q must be written as algorithm:hex", checksum)
+	}
+
+	var newHash func() hash.Hash
+	switch strings.ToLower(algorithm) {
+	case "sha256":
+		newHash = sha256.New
+	case "sha512":
+		newHash = sha512.New
+	case "sha1":
+		newHash = sha1.New
+	default:
+		return nil, nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+
+	sum, err := hex.DecodeString(digest)
+	if err != nil || len(sum) != newHash().Size() {
+		return nil, nil, fmt.Errorf("invalid %s digest %q", algorithm, digest)
+	}
+	return newHash, sum, nil
+}
+
+// verifyChecksum checks the file at name against the entry's checksum.
+func verifyChecksum(entry ManifestEntry, name string) error {
+	if entry.Checksum == "" {
+		return nil
+	}
+	newHash, expected, err := parseChecksum(entry.Checksum)
+	if err != nil {
+		return err
+	}
+	actual, err := fileDigest(name, newHash)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(actual, expected) {
+		algorithm, _, _ := strings.Cut(entry.Checksum, ":")
+		return &ChecksumError{URL: entry.URL, Expected: entry.Checksum, Actual: algorithm + ":" + hex.EncodeToString(actual)}
+	}
+	return nil
+}
+
+// fileDigest returns the digest of the file at name.
+func fileDigest(name string, newHash func() hash.Hash) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// copyFile copies src to dst through a temporary file, so a partially written
+// copy is never left in place.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// parseManifestText parses one URL per line, skipping blank lines and
+// # comments.
+func parseManifestText(name string, data []byte) ([]ManifestEntry, error) {
+	entries := []ManifestEntry{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		entries = append(entries, ManifestEntry{URL: text, Line: line})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// parseManifestJSON parses a JSON array of entries, keeping track of the line
+// each entry starts on.
+func parseManifestJSON(name string, data []byte) ([]ManifestEntry, error) {
+	lineAt := func(offset int64) int {
+		return bytes.Count(data[:min(int(offset), len(data))], []byte("\n")) + 1
+	}
+	wrap := func(offset int64, err error) error {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			offset = syntax.Offset
+		}
+		return &ManifestError{File: name, Line: lineAt(offset), Err: err}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, wrap(dec.InputOffset(), err)
+	}
+	if tok != json.Delim('[') {
+		return nil, wrap(0, errors.New("manifest must be a JSON array of entries"))
+	}
+
+	entries := []ManifestEntry{}
+	for dec.More() {
+		// Skip past whitespace and commas, so the offset is the entry's start
+		offset := dec.InputOffset()
+		for offset < int64(len(data)) && strings.ContainsRune(" \t\r\n,", rune(data[offset])) {
+			offset++
+		}
+
+		entry := ManifestEntry{}
+		if err := dec.Decode(&entry); err != nil {
+			return nil, wrap(offset, err)
+		}
+		entry.Line = lineAt(offset)
+		entries = append(entries, entry)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, wrap(dec.InputOffset(), err)
+	}
+	return entries, nil
+}
+
+// parseManifestYAML parses a YAML list of entries, each of which is either a
+// mapping of the entry's fields, or a bare URL:
+//
+//	# Critical files first
+//	- url: https://example.com/index.json
+//	  path: index.json
+//	  priority: 10
+//	  headers:
+//	    Authorization: Bearer token
+//	- https://example.com/bulk.bin
+func parseManifestYAML(name string, data []byte) ([]ManifestEntry, error) {
+	fail := func(line int, err error) error {
+		return &ManifestError{File: name, Line: line, Err: err}
+	}
+
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fail(yamlErrorLine(err, 1), err)
+	}
+	entries := []ManifestEntry{}
+	if len(doc.Content) == 0 {
+		return entries, nil
+	}
+	list := doc.Content[0]
+	if list.Kind != yaml.SequenceNode {
+		return nil, fail(list.Line, errors.New("manifest must be a YAML list of entries"))
+	}
+
+	for _, item := range list.Content {
+		entry := ManifestEntry{Line: item.Line}
+		switch item.Kind {
+		case yaml.ScalarNode:
+			entry.URL = item.Value
+		case yaml.MappingNode:
+			// Decoding a node can't reject unknown fields by itself
+			for i := 0; i < len(item.Content); i += 2 {
+				key := item.Content[i]
+				if !slices.Contains(manifestFields, key.Value) {
+					return nil, fail(key.Line, fmt.Errorf("unknown field %q", key.Value))
+				}
+			}
+			if err := item.Decode(&entry); err != nil {
+				return nil, fail(yamlErrorLine(err, item.Line), err)
+			}
+		default:
+			return nil, fail(item.Line, errors.New("entries must be a URL or a mapping"))
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// manifestFields are the fields a YAML entry can have.
+var manifestFields = []string{"url", "path", "checksum", "headers", "priority"}
+
+// yamlLineRe finds the line number in a YAML error.
+var yamlLineRe = regexp.MustCompile(`line (\d+):`)
+
+// yamlErrorLine returns the line a YAML error refers to, or line if it
+// doesn't say.
+func yamlErrorLine(err error, line int) int {
+	if match := yamlLineRe.FindStringSubmatch(err.Error()); match != nil {
+		line, _ = strconv.Atoi(match[1])
+	}
+	return line
+}
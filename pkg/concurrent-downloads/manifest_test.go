@@ -0,0 +1,233 @@
+package concurrentdownloads_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestParseManifest(t *testing.T) {
+	t.Run("it parses plain text", func(t *testing.T) {
+		m, err := ParseManifest("urls.txt", []byte("# assets\nhttps://example.com/a.js\n\nhttps://example.com/\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Entries) != 2 {
+			t.Fatalf("expected 2 entries, got %v", m.Entries)
+		}
+		if m.Entries[0].Line != 2 || m.Entries[0].Path != "a.js" || m.Entries[1].Path != "index.html" {
+			t.Errorf("unexpected entries: %+v", m.Entries)
+		}
+	})
+
+	t.Run("it parses JSON", func(t *testing.T) {
+		data := `[
+  {"url": "https://example.com/index.json", "priority": 10},
+  {
+    "url": "https://example.com/data.bin",
+    "path": "bulk/data.bin",
+    "headers": {"Authorization": "Bearer token"}
+  }
+]`
+		m, err := ParseManifest("batch.json", []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Entries) != 2 {
+			t.Fatalf("expected 2 entries, got %v", m.Entries)
+		}
+		if m.Entries[0].Priority != 10 || m.Entries[0].Line != 2 {
+			t.Errorf("unexpected entry: %+v", m.Entries[0])
+		}
+		if m.Entries[1].Line != 3 || m.Entries[1].Headers["Authorization"] != "Bearer token" {
+			t.Errorf("unexpected entry: %+v", m.Entries[1])
+		}
+	})
+
+	t.Run("it parses YAML", func(t *testing.T) {
+		data := `---
+# Critical files first
+- url: https://example.com/index.json
+  priority: 10
+  headers:
+    Authorization: "Bearer token"
+    X-Trace: abc # trailing comment
+  path: 'index.json'
+
+- https://example.com/bulk.bin
+- url: https://example.com/other.bin
+  checksum: sha256:` + strings.Repeat("ab", 32) + `
+`
+		m, err := ParseManifest("batch.yaml", []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Entries) != 3 {
+			t.Fatalf("expected 3 entries, got %+v", m.Entries)
+		}
+		first := m.Entries[0]
+		if first.Line != 3 || first.Priority != 10 || first.Path != "index.json" {
+			t.Errorf("unexpected entry: %+v", first)
+		}
+		if first.Headers["Authorization"] != "Bearer token" || first.Headers["X-Trace"] != "abc" {
+			t.Errorf("unexpected headers: %v", first.Headers)
+		}
+		if m.Entries[1].URL != "https://example.com/bulk.bin" || m.Entries[1].Line != 10 {
+			t.Errorf("unexpected entry: %+v", m.Entries[1])
+		}
+		if m.Entries[2].Line != 11 || m.Entries[2].Checksum == "" {
+			t.Errorf("unexpected entry: %+v", m.Entries[2])
+		}
+	})
+
+	t.Run("it parses the documented YAML example", func(t *testing.T) {
+		data := `# Critical files first
+- url: https://example.com/index.json
+  path: index.json
+  priority: 10
+  headers:
+    Authorization: Bearer token
+- https://example.com/bulk.bin
+`
+		m, err := ParseManifest("batch.yml", []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Entries) != 2 || m.Entries[0].Headers["Authorization"] != "Bearer token" || m.Entries[1].Path != "bulk.bin" {
+			t.Errorf("unexpected entries: %+v", m.Entries)
+		}
+	})
+
+	t.Run("it reports every error with its line", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			file  string
+			data  string
+			lines []int
+		}{
+			{"text", "urls.txt", "https://example.com/a\nftp://example.com/b\n\nnot a url\n", []int{2, 4}},
+			{"json syntax", "batch.json", "[\n  {\"url\": \"https://example.com\"},\n  {\"url\": }\n]", []int{3}},
+			{"json field", "batch.json", "[\n  {\"url\": \"https://example.com\", \"bogus\": 1}\n]", []int{2}},
+			{"yaml", "batch.yml", "- url: https://example.com/a\n  priority: high\n", []int{2}},
+			{"yaml field", "batch.yml", "- url: https://example.com/a\n  colour: red\n", []int{2}},
+			{
+				"validation", "batch.json",
+				"[\n{\"url\": \"https://example.com/a\"},\n{\"url\": \"https://example.com/b\", \"path\": \"a\"},\n{\"url\": \"https://example.com/c\", \"path\": \"../c\"},\n{\"url\": \"https://example.com/d\", \"checksum\": \"md5:abc\"}\n]",
+				[]int{3, 4, 5},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := ParseManifest(tt.file, []byte(tt.data))
+				if err == nil {
+					t.Fatal("expected error, got none")
+				}
+
+				lines := []int{}
+				for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+					var manifestErr *ManifestError
+					if !errors.As(err, &manifestErr) {
+						t.Fatalf("expected ManifestError, got %v", err)
+					}
+					if manifestErr.File != tt.file {
+						t.Errorf("expected file %v, got %v", tt.file, manifestErr.File)
+					}
+					lines = append(lines, manifestErr.Line)
+				}
+				if len(lines) != len(tt.lines) {
+					t.Fatalf("expected errors on lines %v, got %v: %v", tt.lines, lines, err)
+				}
+				for i := range lines {
+					if lines[i] != tt.lines[i] {
+						t.Errorf("expected errors on lines %v, got %v: %v", tt.lines, lines, err)
+					}
+				}
+			})
+		}
+	})
+}
+
+func TestRunManifest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/private" && r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("body of " + r.URL.Path))
+	}))
+	defer srv.Close()
+
+	digest := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	t.Run("it downloads the manifest", func(t *testing.T) {
+		dir := t.TempDir()
+		m := &Manifest{File: "test", Entries: []ManifestEntry{
+			{URL: srv.URL + "/index.json", Checksum: digest("body of /index.json")},
+			{URL: srv.URL + "/private", Path: "nested/private.txt", Headers: map[string]string{"Authorization": "Bearer token"}},
+		}}
+		if err := m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := (&Downloader{}).RunManifest(t.Context(), m, dir); err != nil {
+			t.Fatal(err)
+		}
+		for name, expected := range map[string]string{
+			"index.json":         "body of /index.json",
+			"nested/private.txt": "body of /private",
+		} {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil || string(data) != expected {
+				t.Errorf("expected %v to contain %q, got %q, %v", name, expected, data, err)
+			}
+		}
+	})
+
+	t.Run("it fails on checksum mismatch", func(t *testing.T) {
+		dir := t.TempDir()
+		m := &Manifest{File: "test", Entries: []ManifestEntry{
+			{URL: srv.URL + "/index.json", Path: "index.json", Checksum: digest("something else")},
+		}}
+
+		err := (&Downloader{}).RunManifest(t.Context(), m, dir)
+		var checksumErr *ChecksumError
+		if !errors.As(err, &checksumErr) {
+			t.Fatalf("expected ChecksumError, got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "index.json")); !os.IsNotExist(err) {
+			t.Errorf("expected nothing to be written, got %v", err)
+		}
+	})
+
+	t.Run("it collects every error", func(t *testing.T) {
+		dir := t.TempDir()
+		m := &Manifest{File: "test", Entries: []ManifestEntry{
+			{URL: srv.URL + "/index.json", Path: "index.json", Checksum: digest("something else")},
+			{URL: srv.URL + "/private", Path: "private.txt"},
+			// Last in line, so it would be canceled by failing fast
+			{URL: srv.URL + "/public", Path: "public.txt", Priority: -1},
+		}}
+
+		d := &Downloader{Concurrency: 1, ErrorPolicy: CollectErrors}
+		err := d.RunManifest(t.Context(), m, dir)
+		var checksumErr *ChecksumError
+		var statusErr *StatusError
+		if !errors.As(err, &checksumErr) || !errors.As(err, &statusErr) {
+			t.Errorf("expected both errors, got %v", err)
+		}
+		if data, err := os.ReadFile(filepath.Join(dir, "public.txt")); string(data) != "body of /public" {
+			t.Errorf("expected the rest to be downloaded, got %q, %v", data, err)
+		}
+	})
+}
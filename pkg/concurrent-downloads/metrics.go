@@ -0,0 +1,324 @@
+package concurrentdownloads
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the latency histogram bucket bounds, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// phases are the request phases we keep latency histograms for.
+var phases = []string{"dns", "connect", "tls", "ttfb", "total"}
+
+// Metrics collects counters and latency histograms for a Downloader.
+//
+// It can be published with [expvar] through [Metrics.Publish], and it is an
+// [http.Handler] serving the Prometheus text exposition format. A nil
+// *Metrics discards everything.
+type Metrics struct {
+	// Buckets are the histogram bucket bounds in seconds, and default to
+	// [DefaultBuckets]. They can't be changed once anything is recorded.
+	Buckets []float64
+
+	mu        sync.Mutex
+	requests  int64
+	bytes     int64
+	retries   int64
+	errors    map[string]int64
+	responses map[int]int64
+	latency   map[string]map[string]*histogram
+}
+
+// histogram counts observations in cumulative buckets, like Prometheus.
+type histogram struct {
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+func (m *Metrics) request() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests++
+}
+
+func (m *Metrics) retry() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries++
+}
+
+func (m *Metrics) response(code int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.responses == nil {
+		m.responses = map[int]int64{}
+	}
+	m.responses[code]++
+}
+
+func (m *Metrics) error(err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.errors == nil {
+		m.errors = map[string]int64{}
+	}
+	m.errors[errorType(err)]++
+}
+
+// observe records the bytes and phase latencies of a finished request.
+func (m *Metrics) observe(host string, t Timing, n int64) {
+	if m == nil {
+		return
+	}
+	durations := map[string]time.Duration{
+		"dns":     t.DNS,
+		"connect": t.Connect,
+		"tls":     t.TLS,
+		"ttfb":    t.FirstByte,
+		"total":   t.Total,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytes += n
+
+	buckets := m.buckets()
+	if m.latency == nil {
+		m.latency = map[string]map[string]*histogram{}
+	}
+	if m.latency[host] == nil {
+		m.latency[host] = map[string]*histogram{}
+	}
+	for _, phase := range phases {
+		// Phases that didn't happen, like dialing on a reused connection,
+		// would skew the histograms towards zero
+		d := durations[phase]
+		if d == 0 && phase != "total" {
+			continue
+		}
+		h := m.latency[host][phase]
+		if h == nil {
+			h = &histogram{counts: make([]int64, len(buckets))}
+			m.latency[host][phase] = h
+		}
+		seconds := d.Seconds()
+		for i, bound := range buckets {
+			if seconds <= bound {
+				h.counts[i]++
+			}
+		}
+		h.count++
+		h.sum += seconds
+	}
+}
+
+func (m *Metrics) buckets() []float64 {
+	if len(m.Buckets) == 0 {
+		return DefaultBuckets
+	}
+	return m.Buckets
+}
+
+// errorType classifies an error for the errors counter.
+func errorType(err error) string {
+	var status *StatusError
+	var dns *net.DNSError
+	var tlsErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var pinErr *PinError
+	var contentType *ContentTypeError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &status):
+		return "status"
+	case errors.As(err, &contentType):
+		return "content_type"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &dns):
+		return "dns"
+	case errors.As(err, &tlsErr), errors.As(err, &recordErr), errors.As(err, &pinErr):
+		return "tls"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "body"
+	case errors.As(err, &netErr):
+		return "network"
+	}
+	return "other"
+}
+
+// MetricsSnapshot is a point in time copy of the metrics, as published to
+// expvar.
+type MetricsSnapshot struct {
+	Requests  int64                                   `json:"requests"`
+	Bytes     int64                                   `json:"bytes"`
+	Retries   int64                                   `json:"retries"`
+	Errors    map[string]int64                        `json:"errors"`
+	Responses map[int]int64                           `json:"responses"`
+	Latency   map[string]map[string]HistogramSnapshot `json:"latency"`
+}
+
+// HistogramSnapshot is a copy of a latency histogram, where Counts[i] is the
+// number of observations less than or equal to Buckets[i] seconds.
+type HistogramSnapshot struct {
+	Buckets []float64 `json:"buckets"`
+	Counts  []int64   `json:"counts"`
+	Count   int64     `json:"count"`
+	Sum     float64   `json:"sum"`
+}
+
+// Snapshot returns a copy of the current metrics.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	if m == nil {
+		return MetricsSnapshot{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	buckets := m.buckets()
+	s := MetricsSnapshot{
+		Requests:  m.requests,
+		Bytes:     m.bytes,
+		Retries:   m.retries,
+		Errors:    maps.Clone(m.errors),
+		Responses: maps.Clone(m.responses),
+		Latency:   map[string]map[string]HistogramSnapshot{},
+	}
+	for host, hists := range m.latency {
+		s.Latency[host] = map[string]HistogramSnapshot{}
+		for phase, h := range hists {
+			s.Latency[host][phase] = HistogramSnapshot{
+				Buckets: slices.Clone(buckets),
+				Counts:  slices.Clone(h.counts),
+				Count:   h.count,
+				Sum:     h.sum,
+			}
+		}
+	}
+	return s
+}
+
+// String returns the metrics as JSON, which makes Metrics an [expvar.Var].
+func (m *Metrics) String() string {
+	data, _ := json.Marshal(m.Snapshot())
+	return string(data)
+}
+
+// Publish publishes the metrics to expvar under name. Like [expvar.Publish],
+// it panics if the name is already registered.
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, m)
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WritePrometheus(w)
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	s := m.Snapshot()
+	p := &promWriter{w: w}
+
+	p.metric("downloads_requests_total", "counter", "Requests sent, including retries.")
+	p.sample("downloads_requests_total", "", float64(s.Requests))
+	p.metric("downloads_bytes_total", "counter", "Response body bytes received.")
+	p.sample("downloads_bytes_total", "", float64(s.Bytes))
+	p.metric("downloads_retries_total", "counter", "Requests which were retried.")
+	p.sample("downloads_retries_total", "", float64(s.Retries))
+
+	p.metric("downloads_errors_total", "counter", "Failed requests by error type.")
+	for _, kind := range slices.Sorted(maps.Keys(s.Errors)) {
+		p.sample("downloads_errors_total", p.labels("type", kind), float64(s.Errors[kind]))
+	}
+
+	p.metric("downloads_responses_total", "counter", "Responses by status code.")
+	for _, code := range slices.Sorted(maps.Keys(s.Responses)) {
+		p.sample("downloads_responses_total", p.labels("code", strconv.Itoa(code)), float64(s.Responses[code]))
+	}
+
+	p.metric("downloads_latency_seconds", "histogram", "Request phase latency by host.")
+	for _, host := range slices.Sorted(maps.Keys(s.Latency)) {
+		for _, phase := range phases {
+			h, ok := s.Latency[host][phase]
+			if !ok {
+				continue
+			}
+			for i, bound := range h.Buckets {
+				le := strconv.FormatFloat(bound, 'g', -1, 64)
+				p.sample("downloads_latency_seconds_bucket", p.labels("host", host, "phase", phase, "le", le), float64(h.Counts[i]))
+			}
+			p.sample("downloads_latency_seconds_bucket", p.labels("host", host, "phase", phase, "le", "+Inf"), float64(h.Count))
+			p.sample("downloads_latency_seconds_sum", p.labels("host", host, "phase", phase), h.Sum)
+			p.sample("downloads_latency_seconds_count", p.labels("host", host, "phase", phase), float64(h.Count))
+		}
+	}
+	return p.err
+}
+
+// promWriter writes the text exposition format, keeping the first error.
+type promWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *promWriter) metric(name, kind, help string) {
+	p.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (p *promWriter) sample(name, labels string, value float64) {
+	p.printf("%s%s %s\n", name, labels, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// labelEscaper escapes label values as the exposition format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats key value pairs as {key="value",...}.
+func (p *promWriter) labels(pairs ...string) string {
+	out := "{"
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			out += ","
+		}
+		out += fmt.Sprintf(`%s="%s"`, pairs[i], labelEscaper.Replace(pairs[i+1]))
+	}
+	return out + "}"
+}
+
+func (p *promWriter) printf(format string, args ...any) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
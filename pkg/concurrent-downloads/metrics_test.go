@@ -0,0 +1,99 @@
+package concurrentdownloads_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestMetrics(t *testing.T) {
+	flaky := atomic.Int64{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	mux.HandleFunc("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		if flaky.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("world"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	m := &Metrics{}
+	d := &Downloader{Retries: 1, Metrics: m}
+	if _, err := d.DownloadAll(t.Context(), []string{srv.URL + "/ok", srv.URL + "/flaky"}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("it counts requests", func(t *testing.T) {
+		s := m.Snapshot()
+		if s.Requests != 3 || s.Retries != 1 || s.Bytes != 10 {
+			t.Errorf("unexpected counters: %+v", s)
+		}
+		if s.Responses[200] != 2 || s.Responses[503] != 1 {
+			t.Errorf("unexpected responses: %v", s.Responses)
+		}
+		if s.Errors["status"] != 1 {
+			t.Errorf("unexpected errors: %v", s.Errors)
+		}
+	})
+
+	t.Run("it records latency by host", func(t *testing.T) {
+		s := m.Snapshot()
+		for _, phase := range []string{"connect", "ttfb", "total"} {
+			h, ok := s.Latency[host][phase]
+			if !ok || h.Count == 0 {
+				t.Errorf("expected %v latency for %v, got %+v", phase, host, s.Latency)
+			}
+		}
+		if h := s.Latency[host]["total"]; h.Count != 3 || h.Counts[len(h.Counts)-1] != 3 {
+			t.Errorf("unexpected total histogram: %+v", h)
+		}
+	})
+
+	t.Run("it publishes to expvar", func(t *testing.T) {
+		m.Publish("downloads_test")
+		s := MetricsSnapshot{}
+		if err := json.Unmarshal([]byte(expvar.Get("downloads_test").String()), &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Requests != 3 {
+			t.Errorf("unexpected expvar snapshot: %+v", s)
+		}
+	})
+
+	t.Run("it serves the prometheus format", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		body := rec.Body.String()
+		expected := []string{
+			"# TYPE downloads_requests_total counter\ndownloads_requests_total 3\n",
+			"downloads_retries_total 1\n",
+			"downloads_bytes_total 10\n",
+			`downloads_errors_total{type="status"} 1`,
+			`downloads_responses_total{code="503"} 1`,
+			"# TYPE downloads_latency_seconds histogram\n",
+			`downloads_latency_seconds_bucket{host="` + host + `",phase="total",le="+Inf"} 3`,
+			`downloads_latency_seconds_count{host="` + host + `",phase="total"} 3`,
+		}
+		for _, line := range expected {
+			if !strings.Contains(body, line) {
+				t.Errorf("expected %q in:\n%v", line, body)
+			}
+		}
+		if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+			t.Errorf("unexpected content type: %v", rec.Header().Get("Content-Type"))
+		}
+	})
+}
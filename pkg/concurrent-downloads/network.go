@@ -0,0 +1,189 @@
+package concurrentdownloads
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proxy routes requests for matching hosts through a proxy.
+type Proxy struct {
+	// Host is the host name to match. A leading dot matches all of its
+	// subdomains, and "*" matches every host.
+	Host string
+	// URL is the proxy to use, with an http, https or socks5 scheme.
+	URL string
+}
+
+// transport returns the transport requests are sent with. Downloaders without
+// any network or TLS options share the default transport and its connection
+// pool.
+func (d *Downloader) transport() (http.RoundTripper, error) {
+	d.transportOnce.Do(func() {
+		if len(d.Proxies) == 0 && len(d.NoProxy) == 0 && len(d.Resolve) == 0 && d.DNSCacheTTL == 0 && d.TLS == nil {
+			d.roundTripper = http.DefaultTransport
+			return
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if d.TLS != nil {
+			transport.TLSClientConfig, d.transportErr = d.TLS.config()
+			if d.transportErr != nil {
+				return
+			}
+		}
+		transport.Proxy = http.ProxyFromEnvironment
+		if len(d.Proxies) > 0 || len(d.NoProxy) > 0 {
+			transport.Proxy = d.proxy
+		}
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		var cache *dnsCache
+		if d.DNSCacheTTL > 0 {
+			cache = &dnsCache{ttl: d.DNSCacheTTL, entries: map[string]dnsEntry{}}
+		}
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return d.dial(ctx, dialer, cache, network, addr)
+		}
+		d.roundTripper = transport
+		if d.TLS != nil {
+			d.roundTripper = tlsHostTransport{transport}
+		}
+	})
+	return d.roundTripper, d.transportErr
+}
+
+// proxy picks the proxy for req from Proxies, the first match wins. Hosts in
+// NoProxy, or without a match, are connected to directly.
+func (d *Downloader) proxy(req *http.Request) (*url.URL, error) {
+	host := req.URL.Hostname()
+	for _, pattern := range d.NoProxy {
+		if bypassProxy(pattern, host, req.URL.Port()) {
+			return nil, nil
+		}
+	}
+	for _, proxy := range d.Proxies {
+		if matchHost(proxy.Host, host) {
+			return url.Parse(proxy.URL)
+		}
+	}
+	return nil, nil
+}
+
+// bypassProxy reports whether a NoProxy pattern matches the host and port. It
+// follows NO_PROXY: a pattern matches the host and its subdomains, with or
+// without a leading dot, and may also be "*", an IP range in CIDR notation,
+// or include a port.
+func bypassProxy(pattern, host, port string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	host = strings.ToLower(host)
+	if pattern == "*" {
+		return true
+	}
+	if _, network, err := net.ParseCIDR(pattern); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && network.Contains(ip)
+	}
+	if h, p, err := net.SplitHostPort(pattern); err == nil {
+		if p != port {
+			return false
+		}
+		pattern = h
+	}
+	pattern = strings.TrimPrefix(pattern, ".")
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+// matchHost matches a host name pattern the same way as [Crawler.Allow], with
+// "*" matching every host.
+func matchHost(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	host = strings.ToLower(host)
+	return pattern == "*" || host == pattern || (strings.HasPrefix(pattern, ".") && strings.HasSuffix(host, pattern))
+}
+
+// dial connects to addr, using the address from Resolve if there is one, and
+// the DNS cache when it's enabled.
+func (d *Downloader) dial(ctx context.Context, dialer *net.Dialer, cache *dnsCache, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if override, ok := d.resolve(host, port); ok {
+		return dialer.DialContext(ctx, network, override)
+	}
+	if cache == nil || net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	ips, err := cache.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	// Try each address in turn, like the dialer does with its own lookups
+	errs := []error{}
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// resolve looks host up in Resolve, preferring a "host:port" key over a bare
+// "host". The override may be an IP, which keeps the port, or an "ip:port".
+func (d *Downloader) resolve(host, port string) (string, bool) {
+	override, ok := d.Resolve[net.JoinHostPort(host, port)]
+	if !ok {
+		override, ok = d.Resolve[host]
+	}
+	if !ok {
+		return "", false
+	}
+	if _, _, err := net.SplitHostPort(override); err == nil {
+		return override, true
+	}
+	return net.JoinHostPort(strings.Trim(override, "[]"), port), true
+}
+
+// dnsCache caches host name lookups for a fixed time.
+type dnsCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	ips     []string
+	expires time.Time
+}
+
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.ips, nil
+	}
+
+	// Failed lookups aren't cached, so they're retried on the next request
+	ips, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[host] = dnsEntry{ips: ips, expires: time.Now().Add(c.ttl)}
+	return ips, nil
+}
@@ -0,0 +1,166 @@
+package concurrentdownloads_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestNetwork(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("direct " + r.Host))
+	}))
+	defer srv.Close()
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+
+	// An HTTP proxy which answers for every host itself
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxied " + r.URL.Host))
+	}))
+	defer proxy.Close()
+
+	t.Run("it overrides addresses", func(t *testing.T) {
+		d := &Downloader{Resolve: map[string]string{"example.test": "127.0.0.1"}}
+		body, err := d.FetchURL(t.Context(), "http://example.test:"+strconv.Itoa(port)+"/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "direct example.test:"+strconv.Itoa(port) {
+			t.Errorf("unexpected body %q", body)
+		}
+	})
+
+	t.Run("it overrides ports", func(t *testing.T) {
+		d := &Downloader{Resolve: map[string]string{"example.test:80": srv.Listener.Addr().String()}}
+		body, err := d.FetchURL(t.Context(), "http://example.test/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "direct example.test" {
+			t.Errorf("unexpected body %q", body)
+		}
+	})
+
+	t.Run("it proxies matching hosts", func(t *testing.T) {
+		d := &Downloader{
+			Proxies: []Proxy{{Host: ".example.test", URL: proxy.URL}},
+			NoProxy: []string{"skip.example.test"},
+			Resolve: map[string]string{"skip.example.test": "127.0.0.1"},
+		}
+		cases := map[string]string{
+			"http://www.example.test/":                         "proxied www.example.test",
+			"http://skip.example.test:" + strconv.Itoa(port):   "direct skip.example.test:" + strconv.Itoa(port),
+			"http://127.0.0.1:" + strconv.Itoa(port) + "/path": "direct 127.0.0.1:" + strconv.Itoa(port),
+		}
+		for url, expected := range cases {
+			body, err := d.FetchURL(t.Context(), url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != expected {
+				t.Errorf("expected %q for %v, got %q", expected, url, body)
+			}
+		}
+	})
+
+	t.Run("it proxies through socks5", func(t *testing.T) {
+		socks, targets := socks5Server(t)
+		d := &Downloader{
+			Proxies: []Proxy{{Host: "*", URL: "socks5://" + socks}},
+		}
+		body, err := d.FetchURL(t.Context(), "http://example.test:"+strconv.Itoa(port)+"/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "direct example.test:"+strconv.Itoa(port) {
+			t.Errorf("unexpected body %q", body)
+		}
+		// The proxy resolves the name, not us
+		if target := <-targets; target != "example.test:"+strconv.Itoa(port) {
+			t.Errorf("unexpected socks5 target %q", target)
+		}
+	})
+
+	t.Run("it caches lookups", func(t *testing.T) {
+		d := &Downloader{DNSCacheTTL: time.Minute}
+		for range 2 {
+			body, err := d.FetchURL(t.Context(), "http://localhost:"+strconv.Itoa(port)+"/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(body), "direct localhost") {
+				t.Errorf("unexpected body %q", body)
+			}
+		}
+	})
+
+	t.Run("it reports bad proxies", func(t *testing.T) {
+		d := &Downloader{Proxies: []Proxy{{Host: "*", URL: "://bad"}}}
+		_, err := d.FetchURL(t.Context(), srv.URL)
+		if _, ok := err.(*url.Error); !ok {
+			t.Errorf("expected a url error, got %v", err)
+		}
+	})
+}
+
+// socks5Server runs a minimal SOCKS5 proxy which only supports CONNECT without
+// authentication, and sends each target it's asked for on the channel. Every
+// target is connected to on localhost.
+func socks5Server(t *testing.T) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	targets := make(chan string, 10)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				// Greeting, then pick no authentication
+				header := make([]byte, 2)
+				if _, err := io.ReadFull(conn, header); err != nil {
+					return
+				}
+				io.ReadFull(conn, make([]byte, header[1]))
+				conn.Write([]byte{5, 0})
+
+				// CONNECT request, only domain names are expected
+				request := make([]byte, 5)
+				if _, err := io.ReadFull(conn, request); err != nil || request[3] != 3 {
+					return
+				}
+				rest := make([]byte, int(request[4])+2)
+				io.ReadFull(conn, rest)
+				host := string(rest[:request[4]])
+				port := binary.BigEndian.Uint16(rest[request[4]:])
+				targets <- net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+				upstream, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+				if err != nil {
+					conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer upstream.Close()
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return l.Addr().String(), targets
+}
@@ -0,0 +1,162 @@
+package concurrentdownloads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Stage is a step of a [Pipeline] which turns each In into an Out.
+type Stage[In, Out any] struct {
+	// Name is optional, and prefixes the stage's errors.
+	Name string
+	// Concurrency is the number of workers running Fn, defaults to one.
+	Concurrency int
+	// Buffer is how many outputs can wait for the next stage before the
+	// workers block.
+	Buffer int
+	Fn     func(ctx context.Context, in In) (Out, error)
+}
+
+// Pipeline is a chain of stages, starting with downloads, which each hand
+// their output on to the next one through a buffered channel. A stage whose
+// buffer is full blocks until the next stage catches up, and cancellation
+// stops every stage.
+//
+// Errors are handled according to the Downloader's ErrorPolicy: FailFast
+// cancels the whole pipeline, while CollectErrors drops the item that failed
+// and carries on.
+type Pipeline[T any] struct {
+	p   *pipeline
+	out <-chan T
+}
+
+// pipeline is the state shared by every stage.
+type pipeline struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	policy ErrorPolicy
+	wg     sync.WaitGroup
+	batch  *batchHooks
+
+	mu   sync.Mutex
+	errs []error
+}
+
+// NewPipeline starts a pipeline which downloads items in priority order, with
+// buffer results waiting for the next stage. The downloads run on the
+// Downloader's Concurrency workers, or by default one per buffered result, up
+// to a few, so they never get far ahead of the stages after them.
+func NewPipeline(ctx context.Context, d *Downloader, items []Item, buffer int) *Pipeline[*Result] {
+	if d == nil {
+		d = &Downloader{}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	p := &pipeline{ctx: ctx, cancel: cancel, policy: d.ErrorPolicy, batch: d.Hooks.startBatch(d, len(items))}
+	out := make(chan *Result, buffer)
+
+	q := newQueue(d.Aging)
+	q.push(items...)
+	q.close()
+
+	p.wg.Go(func() {
+		defer close(out)
+		d.run(ctx, q, d.workers(min(buffer, defaultConcurrency)), func(item Item) {
+			start := time.Now()
+			result, err := d.download(ctx, item.URL, item.Header)
+			p.batch.item(item.URL, start, err)
+			if err != nil {
+				p.fail(err)
+				return
+			}
+			select {
+			case out <- result:
+			case <-ctx.Done():
+			}
+		})
+	})
+	return &Pipeline[*Result]{p: p, out: out}
+}
+
+// Then adds a stage to the end of the pipeline.
+func Then[In, Out any](p *Pipeline[In], stage Stage[In, Out]) *Pipeline[Out] {
+	ctx := p.p.ctx
+	out := make(chan Out, stage.Buffer)
+
+	workers := sync.WaitGroup{}
+	for range max(stage.Concurrency, 1) {
+		workers.Go(func() {
+			// Keep draining after cancellation, so the stage before never
+			// blocks on a send
+			for in := range p.out {
+				if ctx.Err() != nil {
+					continue
+				}
+				v, err := stage.Fn(ctx, in)
+				if err != nil {
+					if stage.Name != "" {
+						err = fmt.Errorf("%s: %w", stage.Name, err)
+					}
+					p.p.fail(err)
+					continue
+				}
+				select {
+				case out <- v:
+				case <-ctx.Done():
+				}
+			}
+		})
+	}
+	p.p.wg.Go(func() {
+		workers.Wait()
+		close(out)
+	})
+	return &Pipeline[Out]{p: p.p, out: out}
+}
+
+// Sink ends the pipeline with concurrency workers calling fn for each item,
+// and waits for every stage to finish.
+func (p *Pipeline[T]) Sink(concurrency int, fn func(ctx context.Context, v T) error) error {
+	last := Then(p, Stage[T, struct{}]{
+		Name:        "sink",
+		Concurrency: concurrency,
+		Fn: func(ctx context.Context, v T) (struct{}, error) {
+			return struct{}{}, fn(ctx, v)
+		},
+	})
+	for range last.out {
+	}
+	return last.p.wait()
+}
+
+// fail handles an error from any stage according to the policy.
+func (p *pipeline) fail(err error) {
+	if p.policy != CollectErrors {
+		p.cancel(err)
+		return
+	}
+	if p.ctx.Err() != nil {
+		// Cancellation is reported once, not for every item
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.errs = append(p.errs, err)
+}
+
+// wait waits for every stage to stop, and returns the pipeline's error.
+func (p *pipeline) wait() error {
+	p.wg.Wait()
+	err := context.Cause(p.ctx)
+	p.cancel(nil)
+
+	if p.policy == CollectErrors {
+		p.mu.Lock()
+		err = errors.Join(append(p.errs, err)...)
+		p.mu.Unlock()
+	}
+	p.batch.finish(p.ctx, err)
+	return err
+}
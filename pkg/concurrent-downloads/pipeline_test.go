@@ -0,0 +1,176 @@
+package concurrentdownloads_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestPipeline(t *testing.T) {
+	served := atomic.Int64{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+		w.Write([]byte(strings.TrimPrefix(r.URL.Path, "/")))
+	}))
+	defer srv.Close()
+
+	items := func(n int) []Item {
+		items := make([]Item, n)
+		for i := range items {
+			items[i] = Item{URL: fmt.Sprintf("%s/item-%d", srv.URL, i)}
+		}
+		return items
+	}
+	upper := Stage[*Result, string]{
+		Name:        "upper",
+		Concurrency: 2,
+		Buffer:      1,
+		Fn: func(ctx context.Context, result *Result) (string, error) {
+			return strings.ToUpper(string(result.Body)), nil
+		},
+	}
+
+	t.Run("it runs each stage in turn", func(t *testing.T) {
+		p := NewPipeline(t.Context(), &Downloader{Concurrency: 3}, items(10), 2)
+		lengths := Then(Then(p, upper), Stage[string, int]{
+			Concurrency: 2,
+			Fn: func(ctx context.Context, s string) (int, error) {
+				if !strings.HasPrefix(s, "ITEM-") {
+					return 0, fmt.Errorf("unexpected %q", s)
+				}
+				return len(s), nil
+			},
+		})
+
+		total := atomic.Int64{}
+		err := lengths.Sink(2, func(ctx context.Context, n int) error {
+			total.Add(int64(n))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Ten of "ITEM-n"
+		if total.Load() != 60 {
+			t.Errorf("expected 60, got %v", total.Load())
+		}
+	})
+
+	t.Run("it applies backpressure", func(t *testing.T) {
+		served.Store(0)
+		lead := int64(0)
+		consumed := int64(0)
+		mu := sync.Mutex{}
+
+		p := Then(NewPipeline(t.Context(), &Downloader{Concurrency: 1}, items(20), 0), Stage[*Result, string]{
+			Fn: upper.Fn,
+		})
+		err := p.Sink(1, func(ctx context.Context, s string) error {
+			time.Sleep(2 * time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			consumed++
+			lead = max(lead, served.Load()-consumed)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// One download, one transform and one in the hand off to each stage
+		if lead > 4 {
+			t.Errorf("downloads got %v ahead of the sink", lead)
+		}
+	})
+
+	t.Run("it doesn't download far ahead by default", func(t *testing.T) {
+		served.Store(0)
+		lead := int64(0)
+		consumed := int64(0)
+		mu := sync.Mutex{}
+
+		err := NewPipeline(t.Context(), &Downloader{}, items(50), 2).Sink(1, func(ctx context.Context, r *Result) error {
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			consumed++
+			lead = max(lead, served.Load()-consumed)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Two downloads, two buffered and one in the hand off to the sink
+		if lead > 5 {
+			t.Errorf("downloads got %v ahead of the sink", lead)
+		}
+	})
+
+	t.Run("it cancels every stage on the first error", func(t *testing.T) {
+		served.Store(0)
+		failing := Stage[*Result, string]{
+			Name: "parse",
+			Fn: func(ctx context.Context, result *Result) (string, error) {
+				if strings.HasSuffix(result.URL, "item-2") {
+					return "", errors.New("bad item")
+				}
+				return string(result.Body), nil
+			},
+		}
+		p := Then(NewPipeline(t.Context(), &Downloader{Concurrency: 1}, items(50), 0), failing)
+		err := p.Sink(1, func(ctx context.Context, s string) error {
+			time.Sleep(time.Millisecond)
+			return nil
+		})
+		if err == nil || err.Error() != "parse: bad item" {
+			t.Errorf("expected the stage error, got %v", err)
+		}
+		if served.Load() > 10 {
+			t.Errorf("expected downloads to stop, got %v", served.Load())
+		}
+	})
+
+	t.Run("it collects errors when asked to", func(t *testing.T) {
+		d := &Downloader{Concurrency: 2, ErrorPolicy: CollectErrors}
+		p := Then(NewPipeline(t.Context(), d, items(10), 0), Stage[*Result, string]{
+			Fn: func(ctx context.Context, result *Result) (string, error) {
+				if strings.HasSuffix(result.URL, "0") || strings.HasSuffix(result.URL, "5") {
+					return "", errors.New("bad item")
+				}
+				return string(result.Body), nil
+			},
+		})
+
+		count := atomic.Int64{}
+		err := p.Sink(1, func(ctx context.Context, s string) error {
+			count.Add(1)
+			return nil
+		})
+		if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 2 {
+			t.Errorf("expected 2 errors, got %v", err)
+		}
+		if count.Load() != 8 {
+			t.Errorf("expected 8 items, got %v", count.Load())
+		}
+	})
+
+	t.Run("it stops when the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		p := Then(NewPipeline(ctx, &Downloader{Concurrency: 1}, items(50), 0), upper)
+		err := p.Sink(1, func(ctx context.Context, s string) error {
+			cancel()
+			return nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected cancellation, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package concurrentdownloads
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// queue is a blocking priority queue of items shared by the worker pool.
+//
+// Items are taken highest effective priority first, then earliest deadline
+// first, then in the order they were pushed. The effective priority is the
+// item's Priority plus one for every aging interval it has spent waiting.
+type queue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  queueHeap
+	seq    uint64
+	aging  time.Duration
+	aged   time.Time
+	closed bool
+}
+
+// queued wraps an Item with its bookkeeping while it waits in the queue.
+type queued struct {
+	Item
+	seq      uint64
+	enqueued time.Time
+	priority int
+}
+
+func newQueue(aging time.Duration) *queue {
+	q := &queue{aging: aging, aged: time.Now()}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds items to the queue and wakes any waiting workers.
+func (q *queue) push(items ...Item) {
+	now := time.Now()
+	q.mu.Lock()
+	for _, item := range items {
+		q.seq++
+		heap.Push(&q.items, &queued{
+			Item:     item,
+			seq:      q.seq,
+			enqueued: now,
+			priority: item.Priority,
+		})
+	}
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// close marks the queue as done, workers exit once it has drained.
+func (q *queue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// wake unblocks all waiting workers so they can check for cancellation.
+func (q *queue) wake() {
+	// Taking the lock ensures a worker between its checks and Wait() doesn't
+	// miss the broadcast
+	q.mu.Lock()
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// pop blocks until an item is ready, and returns false once the queue is
+// closed and drained, or the context is done.
+func (q *queue) pop(ctx context.Context) (Item, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) == 0 && !q.closed && ctx.Err() == nil {
+		q.cond.Wait()
+	}
+	if ctx.Err() != nil || len(q.items) == 0 {
+		return Item{}, false
+	}
+
+	q.age(time.Now())
+	return heap.Pop(&q.items).(*queued).Item, true
+}
+
+// age recomputes effective priorities, at most once per aging interval so a
+// busy queue isn't re-heaped on every pop.
+func (q *queue) age(now time.Time) {
+	if q.aging <= 0 || now.Sub(q.aged) < q.aging {
+		return
+	}
+	q.aged = now
+	for _, item := range q.items {
+		item.priority = item.Priority + int(now.Sub(item.enqueued)/q.aging)
+	}
+	heap.Init(&q.items)
+}
+
+// queueHeap implements heap.Interface for queued items.
+type queueHeap []*queued
+
+func (h queueHeap) Len() int { return len(h) }
+
+func (h queueHeap) Less(i, j int) bool {
+	a, b := h[i], h[j]
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	// Earliest deadline first, and items without a deadline go last
+	if !a.Deadline.Equal(b.Deadline) {
+		if a.Deadline.IsZero() || b.Deadline.IsZero() {
+			return b.Deadline.IsZero()
+		}
+		return a.Deadline.Before(b.Deadline)
+	}
+	return a.seq < b.seq
+}
+
+func (h queueHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *queueHeap) Push(x any) { *h = append(*h, x.(*queued)) }
+
+func (h *queueHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
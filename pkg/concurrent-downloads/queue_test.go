@@ -0,0 +1,66 @@
+package concurrentdownloads
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueueAging(t *testing.T) {
+	popAll := func(q *queue) []string {
+		q.close()
+		urls := []string{}
+		for {
+			item, ok := q.pop(t.Context())
+			if !ok {
+				return urls
+			}
+			urls = append(urls, item.URL)
+		}
+	}
+
+	t.Run("without aging priority wins", func(t *testing.T) {
+		q := newQueue(0)
+		q.push(Item{URL: "low"})
+		time.Sleep(20 * time.Millisecond)
+		q.push(Item{URL: "high", Priority: 1})
+
+		if urls := popAll(q); urls[0] != "high" {
+			t.Errorf("expected high first, got %v", urls)
+		}
+	})
+
+	t.Run("waiting items are aged", func(t *testing.T) {
+		q := newQueue(time.Millisecond)
+		q.push(Item{URL: "low"})
+		time.Sleep(20 * time.Millisecond)
+		q.push(Item{URL: "high", Priority: 1})
+
+		if urls := popAll(q); urls[0] != "low" {
+			t.Errorf("expected low first, got %v", urls)
+		}
+	})
+
+	t.Run("pop returns when canceled", func(t *testing.T) {
+		q := newQueue(0)
+		ctx, cancel := context.WithCancel(t.Context())
+		stop := context.AfterFunc(ctx, q.wake)
+		defer stop()
+
+		done := make(chan bool)
+		go func() {
+			_, ok := q.pop(ctx)
+			done <- ok
+		}()
+		cancel()
+
+		select {
+		case ok := <-done:
+			if ok {
+				t.Error("expected no item")
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout")
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package concurrentdownloads
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// robots holds the rules from a robots.txt that apply to our user agent.
+type robots struct {
+	rules []robotsRule
+}
+
+type robotsRule struct {
+	pattern string
+	allow   bool
+}
+
+// disallowAll is used when robots.txt can't be fetched because of a server or
+// network error, which RFC 9309 says means the whole site is off limits.
+var disallowAll = &robots{rules: []robotsRule{{pattern: "/"}}}
+
+// allowed reports whether path may be crawled. The longest matching rule wins,
+// and allow wins a tie, as described in RFC 9309.
+func (r *robots) allowed(path string) bool {
+	if r == nil {
+		return true
+	}
+	best, allow := -1, true
+	for _, rule := range r.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		if len(rule.pattern) > best || (len(rule.pattern) == best && rule.allow) {
+			best, allow = len(rule.pattern), rule.allow
+		}
+	}
+	return allow
+}
+
+// robotsMatch reports whether path matches a rule's pattern, where "*" matches
+// any sequence of characters, and a "$" at the end anchors the pattern to the
+// end of the path. Otherwise patterns match the start of the path.
+func robotsMatch(pattern, path string) bool {
+	pattern, anchored := strings.CutSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	rest, ok := strings.CutPrefix(path, parts[0])
+	if !ok {
+		return false
+	}
+	if len(parts) == 1 {
+		return !anchored || rest == ""
+	}
+	// Match each part as early as possible, which leaves the most room for
+	// the ones after it
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	last := parts[len(parts)-1]
+	if anchored {
+		return strings.HasSuffix(rest, last)
+	}
+	return strings.Contains(rest, last)
+}
+
+// productToken returns the part of a user agent robots.txt groups are matched
+// against, like "examplebot" for "ExampleBot/1.0 (+https://example.com)".
+func productToken(userAgent string) string {
+	token, _, _ := strings.Cut(userAgent, "/")
+	token, _, _ = strings.Cut(strings.TrimSpace(token), " ")
+	return strings.ToLower(token)
+}
+
+// parseRobots parses a robots.txt, keeping the groups whose user-agent is the
+// product token of userAgent, and falling back to the "*" groups otherwise.
+func parseRobots(text, userAgent string) *robots {
+	userAgent = productToken(userAgent)
+
+	var matched, wildcard []robotsRule
+	var agents []string
+	inRules := false
+	exact, star := false, false
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// A user-agent after rules starts a new group
+			if inRules {
+				agents, inRules = nil, false
+			}
+			agents = append(agents, productToken(value))
+		case "allow", "disallow":
+			inRules = true
+			// An empty disallow allows everything, which is the default
+			if value == "" {
+				continue
+			}
+			rule := robotsRule{pattern: value, allow: key == "allow"}
+			for _, agent := range agents {
+				switch {
+				case agent == "*":
+					star = true
+					wildcard = append(wildcard, rule)
+				case agent == userAgent:
+					exact = true
+					matched = append(matched, rule)
+				}
+			}
+		}
+	}
+
+	switch {
+	case exact:
+		return &robots{rules: matched}
+	case star:
+		return &robots{rules: wildcard}
+	}
+	return nil
+}
+
+// robotsCache fetches and caches robots.txt once per origin.
+type robotsCache struct {
+	mu         sync.Mutex
+	origins    map[string]*robotsEntry
+	userAgent  string
+	downloader *Downloader
+}
+
+type robotsEntry struct {
+	once   sync.Once
+	robots *robots
+}
+
+// allowed reports whether u may be crawled according to its origin's
+// robots.txt.
+func (c *robotsCache) allowed(ctx context.Context, u *url.URL) bool {
+	origin := u.Scheme + "://" + u.Host
+
+	c.mu.Lock()
+	if c.origins == nil {
+		c.origins = map[string]*robotsEntry{}
+	}
+	entry, ok := c.origins[origin]
+	if !ok {
+		entry = &robotsEntry{}
+		c.origins[origin] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		header := http.Header{"User-Agent": {c.userAgent}}
+		// A missing robots.txt allows everything, but an unreachable one
+		// disallows everything
+		result, err := c.downloader.fetch(ctx, origin+"/robots.txt", header)
+		var status *StatusError
+		switch {
+		case err == nil:
+			entry.robots = parseRobots(string(result.Body), c.userAgent)
+		case errors.As(err, &status) && status.StatusCode < 500:
+		default:
+			entry.robots = disallowAll
+		}
+	})
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return entry.robots.allowed(path)
+}
@@ -0,0 +1,88 @@
+package concurrentdownloads_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestRobots(t *testing.T) {
+	// site serves robots.txt with the given status and body, and a home page
+	// linking to each of paths
+	site := func(t *testing.T, status int, robots string, paths ...string) *httptest.Server {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			fmt.Fprint(w, robots)
+		})
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			if r.URL.Path != "/" {
+				fmt.Fprint(w, "page")
+				return
+			}
+			for _, path := range paths {
+				fmt.Fprintf(w, `<a href="%s">link</a>`, path)
+			}
+		})
+		srv := httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+		return srv
+	}
+
+	crawl := func(t *testing.T, srv *httptest.Server, userAgent string) []string {
+		c := &Crawler{MaxDepth: 1, UserAgent: userAgent}
+		data, _ := c.Crawl(t.Context(), []string{srv.URL + "/"})
+		paths := []string{}
+		for url := range data {
+			paths = append(paths, strings.TrimPrefix(url, srv.URL))
+		}
+		slices.Sort(paths)
+		return paths
+	}
+
+	t.Run("it matches wildcards", func(t *testing.T) {
+		robots := "User-agent: *\nDisallow: /*.pdf$\nDisallow: /private*/keys\nAllow: /private*/keys/public\n"
+		srv := site(t, http.StatusOK, robots,
+			"/report.pdf", "/report.pdf.html", "/private-a/keys", "/private-b/keys/public", "/privately")
+
+		expected := []string{"/", "/private-b/keys/public", "/privately", "/report.pdf.html"}
+		if actual := crawl(t, srv, ""); !slices.Equal(actual, expected) {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	})
+
+	t.Run("it matches groups by product token", func(t *testing.T) {
+		robots := "User-agent: bot\nDisallow: /\n\nUser-agent: ExampleBot\nDisallow: /examplebot\n\nUser-agent: *\nDisallow: /everyone\n"
+		srv := site(t, http.StatusOK, robots, "/examplebot", "/everyone")
+
+		expected := []string{"/", "/everyone"}
+		if actual := crawl(t, srv, "ExampleBot/1.0 (+https://example.com/bot)"); !slices.Equal(actual, expected) {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+		expected = []string{"/", "/examplebot"}
+		if actual := crawl(t, srv, "OtherBot/2.0"); !slices.Equal(actual, expected) {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	})
+
+	t.Run("it disallows everything when robots.txt is unavailable", func(t *testing.T) {
+		srv := site(t, http.StatusServiceUnavailable, "", "/page")
+		if actual := crawl(t, srv, ""); len(actual) != 0 {
+			t.Errorf("expected nothing to be crawled, got %v", actual)
+		}
+	})
+
+	t.Run("it allows everything when robots.txt is missing", func(t *testing.T) {
+		srv := site(t, http.StatusNotFound, "", "/page")
+		expected := []string{"/", "/page"}
+		if actual := crawl(t, srv, ""); !slices.Equal(actual, expected) {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	})
+}
@@ -0,0 +1,209 @@
+package concurrentdownloads
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session shares cookies between every request a Downloader makes, and can
+// log in before the first one. It's a [http.CookieJar] which remembers the
+// cookies it's given, so they can be saved to disk between runs.
+//
+// The zero value is an empty session which is kept in memory.
+type Session struct {
+	// Login is optional, and runs once before the first request with a
+	// client which shares the session's cookies. If it fails, requests fail
+	// with its error, and the next request tries it again.
+	Login func(ctx context.Context, client *http.Client) error
+	// PublicSuffixList is optional, and stops cookies being set for a whole
+	// public suffix, like co.uk, as [cookiejar.Options] describes. It must be
+	// set before the session is first used.
+	PublicSuffixList cookiejar.PublicSuffixList
+
+	file string
+
+	// loginMu is held while logging in, so requests wait for it to finish
+	loginMu  sync.Mutex
+	loggedIn bool
+
+	mu      sync.Mutex
+	jar     *cookiejar.Jar
+	cookies map[cookieKey]savedCookie
+}
+
+// cookieKey identifies a cookie the way a jar does, so a newer one replaces
+// it. A domain cookie is shared by every host in its domain, so it's keyed by
+// its Domain alone, and a host-only cookie by its Host.
+type cookieKey struct {
+	Host, Domain, Path, Name string
+}
+
+// savedCookie is a cookie as it's persisted, along with the URL it was set
+// by so it can be set again in the same way.
+type savedCookie struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+// OpenSession returns a session which is saved to file, loading the cookies
+// already saved there.
+func OpenSession(file string) (*Session, error) {
+	s := &Session{file: file}
+
+	data, err := os.ReadFile(file)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return s, nil
+	case err != nil:
+		return nil, err
+	}
+
+	saved := []savedCookie{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("invalid session file %s: %w", file, err)
+	}
+	for _, c := range saved {
+		u, err := url.Parse(c.URL)
+		if err != nil || c.Cookie == nil {
+			continue
+		}
+		s.SetCookies(u, []*http.Cookie{c.Cookie})
+	}
+	return s, nil
+}
+
+// SetCookies implements [http.CookieJar].
+func (s *Session) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	s.jar.SetCookies(u, cookies)
+
+	now := time.Now()
+	for _, c := range cookies {
+		c := *c
+		// A relative lifetime has to be fixed to be saved
+		if c.MaxAge > 0 {
+			c.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+			c.MaxAge = 0
+		}
+		key := cookieKey{Path: cookiePath(u, c.Path), Name: c.Name}
+		if domain := strings.ToLower(strings.TrimPrefix(c.Domain, ".")); domain != "" {
+			key.Domain = domain
+		} else {
+			key.Host = strings.ToLower(u.Hostname())
+		}
+		if c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(now)) {
+			delete(s.cookies, key)
+			continue
+		}
+		s.cookies[key] = savedCookie{URL: u.String(), Cookie: &c}
+	}
+}
+
+// cookiePath returns the path a cookie applies to. A cookie without a valid
+// Path gets the default path of the URL which set it, the directory of its
+// path, as RFC 6265 section 5.1.4 says.
+func cookiePath(u *url.URL, path string) string {
+	if strings.HasPrefix(path, "/") {
+		return path
+	}
+	dir := u.Path
+	if i := strings.LastIndex(dir, "/"); i > 0 {
+		return dir[:i]
+	}
+	return "/"
+}
+
+// Cookies implements [http.CookieJar].
+func (s *Session) Cookies(u *url.URL) []*http.Cookie {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	return s.jar.Cookies(u)
+}
+
+// Save writes the session's unexpired cookies to its file, if it has one.
+// Cookies without an expiry are saved too, since the next run carries on the
+// same session.
+func (s *Session) Save() error {
+	if s == nil || s.file == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	saved := []savedCookie{}
+	for _, c := range s.cookies {
+		if c.Cookie.Expires.IsZero() || c.Cookie.Expires.After(now) {
+			saved = append(saved, c)
+		}
+	}
+	s.mu.Unlock()
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), "."+filepath.Base(s.file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// Cookies are credentials, so the file is only readable by its owner,
+	// which CreateTemp already ensures
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.file)
+}
+
+// init creates the jar on first use. The caller holds s.mu.
+func (s *Session) init() {
+	if s.jar == nil {
+		// cookiejar.New never fails
+		s.jar, _ = cookiejar.New(&cookiejar.Options{PublicSuffixList: s.PublicSuffixList})
+		s.cookies = map[cookieKey]savedCookie{}
+	}
+}
+
+// cookieJar returns the session as a jar, or nil when there's no session.
+func (s *Session) cookieJar() http.CookieJar {
+	if s == nil {
+		return nil
+	}
+	return s
+}
+
+// login runs Login unless it has already succeeded. Requests which arrive
+// while it's running wait for it.
+func (s *Session) login(ctx context.Context, transport http.RoundTripper) error {
+	if s == nil || s.Login == nil {
+		return nil
+	}
+	s.loginMu.Lock()
+	defer s.loginMu.Unlock()
+	if s.loggedIn {
+		return nil
+	}
+	if err := s.Login(ctx, &http.Client{Transport: transport, Jar: s}); err != nil {
+		return fmt.Errorf("session login: %w", err)
+	}
+	s.loggedIn = true
+	return nil
+}
@@ -0,0 +1,156 @@
+package concurrentdownloads_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestSession(t *testing.T) {
+	logins := atomic.Int64{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		logins.Add(1)
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret", Path: "/", MaxAge: 3600})
+	})
+	mux.HandleFunc("/data/", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("session"); err != nil || c.Value != "secret" {
+			http.Error(w, "log in first", http.StatusUnauthorized)
+			return
+		}
+		// Cookies set along the way are kept too
+		http.SetCookie(w, &http.Cookie{Name: "seen", Value: "yes", Path: "/"})
+		w.Write([]byte("data"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	login := func(ctx context.Context, client *http.Client) error {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/login", nil)
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}
+	urls := []string{srv.URL + "/data/a", srv.URL + "/data/b", srv.URL + "/data/c", srv.URL + "/data/d"}
+
+	t.Run("it logs in once before the batch", func(t *testing.T) {
+		logins.Store(0)
+		d := &Downloader{Concurrency: 4, Session: &Session{Login: login}}
+		data, err := d.DownloadAll(t.Context(), urls)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 4 || logins.Load() != 1 {
+			t.Errorf("expected 4 downloads after 1 login, got %v after %v", len(data), logins.Load())
+		}
+	})
+
+	t.Run("it fails without a session", func(t *testing.T) {
+		_, err := (&Downloader{}).DownloadAll(t.Context(), urls[:1])
+		var status *StatusError
+		if !errors.As(err, &status) || status.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected a 401, got %v", err)
+		}
+	})
+
+	t.Run("it reports login errors", func(t *testing.T) {
+		failing := func(ctx context.Context, client *http.Client) error {
+			return errors.New("bad password")
+		}
+		d := &Downloader{Session: &Session{Login: failing}}
+		if _, err := d.DownloadAll(t.Context(), urls[:1]); err == nil || err.Error() != "session login: bad password" {
+			t.Errorf("expected a login error, got %v", err)
+		}
+	})
+
+	t.Run("it persists cookies between runs", func(t *testing.T) {
+		logins.Store(0)
+		file := filepath.Join(t.TempDir(), "cookies.json")
+		session, err := OpenSession(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		session.Login = login
+		if _, err := (&Downloader{Session: session}).DownloadAll(t.Context(), urls[:1]); err != nil {
+			t.Fatal(err)
+		}
+		if err := session.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The next run has no way to log in, so it relies on the saved cookies
+		session, err = OpenSession(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := (&Downloader{Session: session}).DownloadAll(t.Context(), urls); err != nil {
+			t.Fatal(err)
+		}
+		if logins.Load() != 1 {
+			t.Errorf("expected 1 login, got %v", logins.Load())
+		}
+		u := httptest.NewRequest(http.MethodGet, srv.URL+"/data/a", nil).URL
+		if len(session.Cookies(u)) != 2 {
+			t.Errorf("expected both cookies, got %v", session.Cookies(u))
+		}
+	})
+
+	t.Run("it gives cookies without a path the default one", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "cookies.json")
+		session, err := OpenSession(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		login, _ := url.Parse("https://example.com/app/login")
+		session.SetCookies(login, []*http.Cookie{{Name: "id", Value: "1", MaxAge: 3600}})
+		// The same cookie, with its default path spelled out, replaces it
+		other, _ := url.Parse("https://example.com/app/other")
+		session.SetCookies(other, []*http.Cookie{{Name: "id", Value: "2", Path: "/app", MaxAge: 3600}})
+		if err := session.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		session, err = OpenSession(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, _ := url.Parse("https://example.com/app/page")
+		if cookies := session.Cookies(page); len(cookies) != 1 || cookies[0].Value != "2" {
+			t.Errorf("expected only the newer cookie, got %v", cookies)
+		}
+	})
+
+	t.Run("it forgets domain cookies deleted by another host", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "cookies.json")
+		session, err := OpenSession(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		auth, _ := url.Parse("https://auth.example.com/login")
+		session.SetCookies(auth, []*http.Cookie{{Name: "sid", Value: "1", Domain: "example.com", Path: "/", MaxAge: 3600}})
+		www, _ := url.Parse("https://www.example.com/logout")
+		session.SetCookies(www, []*http.Cookie{{Name: "sid", Domain: "example.com", Path: "/", MaxAge: -1}})
+		if err := session.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		session, err = OpenSession(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, u := range []*url.URL{auth, www} {
+			if cookies := session.Cookies(u); len(cookies) != 0 {
+				t.Errorf("expected no cookies for %v, got %v", u, cookies)
+			}
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package concurrentdownloads
+
+import (
+	"context"
+	"sync"
+)
+
+// Sink receives completed downloads from [Downloader.DownloadTo].
+type Sink interface {
+	// Write is called with each download in turn, never concurrently.
+	Write(result *Result) error
+}
+
+// DownloadTo downloads items, and writes each result to sink as it arrives.
+// Results are written in the order of items regardless of which finishes
+// first, so the output is the same from one run to the next. Each URL is
+// downloaded once, in the position it first appears.
+//
+// Errors from downloads and from sink are handled according to the
+// ErrorPolicy. Items which fail are left out.
+func (d *Downloader) DownloadTo(ctx context.Context, sink Sink, items []Item) error {
+	order := map[string]int{}
+	unique := []Item{}
+	for _, item := range items {
+		if _, ok := order[item.URL]; !ok {
+			order[item.URL] = len(unique)
+			unique = append(unique, item)
+		}
+	}
+
+	// Downloads which finish early wait here until everything before them
+	// has been written
+	mu := sync.Mutex{}
+	results := make([]*Result, len(unique))
+	done := make([]bool, len(unique))
+	next := 0
+	var sinkErr error
+
+	return d.each(ctx, unique, func(ctx context.Context, item Item) error {
+		result, err := d.download(ctx, item.URL, item.Header)
+
+		mu.Lock()
+		defer mu.Unlock()
+		i := order[item.URL]
+		done[i] = true
+		results[i] = result
+		if err != nil {
+			results[i] = nil
+		}
+
+		for next < len(unique) && done[next] {
+			if results[next] != nil && sinkErr == nil {
+				sinkErr = sink.Write(results[next])
+				if sinkErr != nil && err == nil {
+					err = sinkErr
+				}
+			}
+			// Written results don't need to be held on to
+			results[next] = nil
+			next++
+		}
+		return err
+	})
+}
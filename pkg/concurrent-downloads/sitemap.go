@@ -0,0 +1,246 @@
+package concurrentdownloads
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidSitemap is wrapped by every [SitemapError].
+var ErrInvalidSitemap = errors.New("invalid sitemap")
+
+// SitemapError describes a sitemap which couldn't be parsed.
+type SitemapError struct {
+	URL  string
+	Line int
+	Err  error
+}
+
+func (e *SitemapError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%v: %s:%d: %v", ErrInvalidSitemap, e.URL, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%v: %s: %v", ErrInvalidSitemap, e.URL, e.Err)
+}
+
+// newSitemapError returns a SitemapError at line, unless err is an XML syntax
+// error which knows its own line.
+func newSitemapError(url string, line int, err error) *SitemapError {
+	var syntax *xml.SyntaxError
+	if errors.As(err, &syntax) {
+		line = syntax.Line
+	}
+	return &SitemapError{URL: url, Line: line, Err: err}
+}
+
+// Unwrap returns both the underlying error and [ErrInvalidSitemap], so either
+// can be matched with errors.Is.
+func (e *SitemapError) Unwrap() []error {
+	return []error{ErrInvalidSitemap, e.Err}
+}
+
+// SitemapURL is a page listed in a sitemap.
+type SitemapURL struct {
+	Loc string
+	// LastMod is zero when the sitemap doesn't say.
+	LastMod time.Time
+}
+
+// SitemapLoader loads the URLs listed in sitemaps, following sitemap index
+// files and gzip'd sitemaps.
+type SitemapLoader struct {
+	// Downloader provides the worker pool used to fetch nested sitemaps, and
+	// to download the pages.
+	Downloader *Downloader
+	// Since filters out pages last modified before it. Pages without a
+	// lastmod are always kept.
+	Since time.Time
+}
+
+// Load returns every page listed in the sitemap at url, and any sitemaps it
+// references, sorted by URL.
+func (l *SitemapLoader) Load(ctx context.Context, url string) ([]SitemapURL, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	d := l.Downloader
+	if d == nil {
+		d = &Downloader{}
+	}
+
+	mu := sync.Mutex{}
+	seen := map[string]bool{url: true}
+	pages := map[string]SitemapURL{}
+
+	d.walk(ctx, []Item{{URL: url}}, func(item Item, push func(Item)) {
+		result, err := d.fetch(ctx, item.URL, nil)
+		if err != nil {
+			if err != context.Canceled && err != context.DeadlineExceeded {
+				cancel(err)
+			}
+			return
+		}
+
+		urls, sitemaps, err := parseSitemap(item.URL, result.Body)
+		if err != nil {
+			cancel(err)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, u := range urls {
+			if !u.LastMod.IsZero() && u.LastMod.Before(l.Since) {
+				continue
+			}
+			pages[u.Loc] = u
+		}
+		for _, s := range sitemaps {
+			if !seen[s.Loc] {
+				seen[s.Loc] = true
+				push(Item{URL: s.Loc})
+			}
+		}
+	})
+
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+
+	urls := slices.Collect(maps.Values(pages))
+	slices.SortFunc(urls, func(a, b SitemapURL) int {
+		return strings.Compare(a.Loc, b.Loc)
+	})
+	return urls, nil
+}
+
+// DownloadAll returns a map of {url:data} for every page listed in the
+// sitemap at url.
+func (l *SitemapLoader) DownloadAll(ctx context.Context, url string) (map[string]string, error) {
+	pages, err := l.Load(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make([]string, len(pages))
+	for i, page := range pages {
+		urls[i] = page.Loc
+	}
+
+	d := l.Downloader
+	if d == nil {
+		d = &Downloader{}
+	}
+	return d.DownloadAll(ctx, urls)
+}
+
+// sitemapEntry is a <url> or <sitemap> element, which share their fields.
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// parseSitemap returns the pages and nested sitemaps listed in a urlset or
+// sitemapindex document, which may be gzip'd.
+func parseSitemap(url string, body []byte) (urls, sitemaps []SitemapURL, err error) {
+	// Sniff for gzip rather than trusting the extension or headers, since
+	// servers often get those wrong for .xml.gz files
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, &SitemapError{URL: url, Err: err}
+		}
+		body, err = io.ReadAll(gz)
+		if err != nil {
+			return nil, nil, &SitemapError{URL: url, Err: err}
+		}
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	root := ""
+	for {
+		line, _ := dec.InputPos()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, newSitemapError(url, line, err)
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if root == "" {
+			root = start.Name.Local
+			if root != "urlset" && root != "sitemapindex" {
+				return nil, nil, &SitemapError{URL: url, Line: line, Err: fmt.Errorf("unexpected root element <%s>", root)}
+			}
+			continue
+		}
+
+		var list *[]SitemapURL
+		switch {
+		case root == "urlset" && start.Name.Local == "url":
+			list = &urls
+		case root == "sitemapindex" && start.Name.Local == "sitemap":
+			list = &sitemaps
+		default:
+			if err := dec.Skip(); err != nil {
+				return nil, nil, newSitemapError(url, line, err)
+			}
+			continue
+		}
+
+		entry := sitemapEntry{}
+		if err := dec.DecodeElement(&entry, &start); err != nil {
+			return nil, nil, newSitemapError(url, line, err)
+		}
+		loc := strings.TrimSpace(entry.Loc)
+		if loc == "" {
+			return nil, nil, &SitemapError{URL: url, Line: line, Err: fmt.Errorf("<%s> is missing <loc>", start.Name.Local)}
+		}
+		lastMod, err := parseLastMod(entry.LastMod)
+		if err != nil {
+			return nil, nil, &SitemapError{URL: url, Line: line, Err: err}
+		}
+		*list = append(*list, SitemapURL{Loc: loc, LastMod: lastMod})
+	}
+
+	if root == "" {
+		return nil, nil, &SitemapError{URL: url, Err: errors.New("empty document")}
+	}
+	return urls, sitemaps, nil
+}
+
+// lastModLayouts are the W3C Datetime formats allowed by the sitemap protocol.
+var lastModLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	time.DateOnly,
+	"2006-01",
+	"2006",
+}
+
+func parseLastMod(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range lastModLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid lastmod %q", value)
+}
@@ -0,0 +1,134 @@
+package concurrentdownloads_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestSitemapLoader(t *testing.T) {
+	gzipped := func(text string) []byte {
+		buf := &bytes.Buffer{}
+		gz := gzip.NewWriter(buf)
+		gz.Write([]byte(text))
+		gz.Close()
+		return buf.Bytes()
+	}
+
+	server := func(t *testing.T, files map[string]string) *httptest.Server {
+		var srv *httptest.Server
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			file, ok := files[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			if strings.Contains(file, "%[1]s") {
+				file = fmt.Sprintf(file, srv.URL)
+			}
+			if strings.HasSuffix(r.URL.Path, ".gz") {
+				w.Write(gzipped(file))
+				return
+			}
+			fmt.Fprint(w, file)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+
+	site := map[string]string{
+		"/sitemap.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>%[1]s/pages.xml</loc></sitemap>
+  <sitemap><loc>%[1]s/posts.xml.gz</loc><lastmod>2024-01-01</lastmod></sitemap>
+  <sitemap><loc>%[1]s/sitemap.xml</loc></sitemap>
+</sitemapindex>`,
+		"/pages.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>%[1]s/</loc><lastmod>2024-06-01T12:00:00+00:00</lastmod></url>
+  <url><loc>%[1]s/about</loc><lastmod>2020-01-01</lastmod><priority>0.5</priority></url>
+</urlset>`,
+		"/posts.xml.gz": `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>%[1]s/posts/1</loc></url>
+  <url><loc>%[1]s/posts/2</loc><lastmod>2023-05</lastmod></url>
+</urlset>`,
+		"/":        "home",
+		"/about":   "about",
+		"/posts/1": "first",
+		"/posts/2": "second",
+	}
+
+	t.Run("it loads nested sitemaps", func(t *testing.T) {
+		srv := server(t, site)
+		l := &SitemapLoader{}
+		urls, err := l.Load(t.Context(), srv.URL+"/sitemap.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{"/", "/about", "/posts/1", "/posts/2"}
+		if len(urls) != len(expected) {
+			t.Fatalf("expected %v urls, got %v", len(expected), urls)
+		}
+		for i, u := range urls {
+			if u.Loc != srv.URL+expected[i] {
+				t.Errorf("expected %v, got %v", srv.URL+expected[i], u.Loc)
+			}
+		}
+		if !urls[3].LastMod.Equal(time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("unexpected lastmod: %v", urls[3].LastMod)
+		}
+	})
+
+	t.Run("it filters by lastmod", func(t *testing.T) {
+		srv := server(t, site)
+		l := &SitemapLoader{Since: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		data, err := l.DownloadAll(t.Context(), srv.URL+"/sitemap.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// /about and /posts/2 are too old, /posts/1 has no lastmod so it's kept
+		if len(data) != 2 || data[srv.URL+"/"] != "home" || data[srv.URL+"/posts/1"] != "first" {
+			t.Errorf("unexpected data: %v", data)
+		}
+	})
+
+	t.Run("it returns typed errors for malformed XML", func(t *testing.T) {
+		tests := []struct {
+			name string
+			file string
+			line int
+		}{
+			{"syntax", "<urlset>\n<url><loc>x</loc>\n</urlset>", 3},
+			{"root", "<html></html>", 1},
+			{"loc", "<urlset>\n\n<url></url></urlset>", 3},
+			{"lastmod", "<urlset>\n<url><loc>x</loc><lastmod>yesterday</lastmod></url></urlset>", 2},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				srv := server(t, map[string]string{"/sitemap.xml": tt.file})
+				_, err := (&SitemapLoader{}).Load(t.Context(), srv.URL+"/sitemap.xml")
+
+				var sitemapErr *SitemapError
+				if !errors.As(err, &sitemapErr) {
+					t.Fatalf("expected SitemapError, got %v", err)
+				}
+				if !errors.Is(err, ErrInvalidSitemap) {
+					t.Errorf("expected ErrInvalidSitemap, got %v", err)
+				}
+				if sitemapErr.Line != tt.line {
+					t.Errorf("expected line %v, got %v", tt.line, sitemapErr.Line)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,383 @@
+package concurrentdownloads
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Store keeps downloads in a directory by the sha256 digest of their content,
+// so the same bytes downloaded from different URLs are only stored once. An
+// index maps each URL to the digest of its content.
+//
+// The layout is:
+//
+//	index.json                   {url: "sha256:hex"}
+//	index.log                    changes since index.json, as JSON lines
+//	blobs/sha256/ab/abcdef...    content, named by its digest
+//	tmp/                         downloads in progress
+//
+// Changes to the index are appended to index.log, and folded into index.json
+// when the store is opened, and whenever the log outgrows the index, like a
+// [Journal].
+type Store struct {
+	dir   string
+	mu    sync.Mutex
+	index map[string]string
+	// logged is how many changes index.log holds.
+	logged int
+	// pending are the temporary files of Puts in progress, which GC leaves
+	// alone.
+	pending map[string]bool
+}
+
+// storeChange is a line of index.log. An empty Digest removes the URL.
+type storeChange struct {
+	URL    string `json:"url"`
+	Digest string `json:"digest,omitempty"`
+}
+
+// OpenStore opens or creates the store in dir.
+func OpenStore(dir string) (*Store, error) {
+	s := &Store{dir: dir, index: map[string]string{}, pending: map[string]bool{}}
+	for _, sub := range []string{"blobs/sha256", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := os.ReadFile(s.indexPath())
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &s.index); err != nil {
+			return nil, fmt.Errorf("invalid store index %s: %w", s.indexPath(), err)
+		}
+	}
+
+	file, err := os.Open(s.logPath())
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		err := s.replay(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.logged > 0 {
+		if err := s.compact(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// replay applies the changes in r to the index.
+func (s *Store) replay(r io.Reader) error {
+	return readJSONLines(r, func(change storeChange) {
+		if change.URL == "" {
+			return
+		}
+		if change.Digest == "" {
+			delete(s.index, change.URL)
+		} else {
+			s.index[change.URL] = change.Digest
+		}
+		s.logged++
+	})
+}
+
+// Put stores the content read from r as the body of url, and returns its
+// digest. Content which is already stored isn't written again.
+func (s *Store) Put(url string, r io.Reader) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "tmp"), "blob.*")
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.pending[tmp.Name()] = true
+	s.mu.Unlock()
+	defer func() {
+		os.Remove(tmp.Name())
+		s.mu.Lock()
+		delete(s.pending, tmp.Name())
+		s.mu.Unlock()
+	}()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return "", err
+	}
+	digest := "sha256:" + hex.EncodeToString(h.Sum(nil))
+
+	// Held across the rename and the index update, so GC never sees a blob
+	// which is about to be referenced
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blob := s.Path(digest)
+	if _, err := os.Stat(blob); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(blob), 0o755); err != nil {
+			return "", err
+		}
+		if err := os.Rename(tmp.Name(), blob); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	if err := s.record(storeChange{URL: url, Digest: digest}); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// Lookup returns the digest stored for url.
+func (s *Store) Lookup(url string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	digest, ok := s.index[url]
+	return digest, ok
+}
+
+// Open opens the content stored for url.
+func (s *Store) Open(url string) (*os.File, error) {
+	digest, ok := s.Lookup(url)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", url, os.ErrNotExist)
+	}
+	return os.Open(s.Path(digest))
+}
+
+// Path returns where the blob for digest is stored.
+func (s *Store) Path(digest string) string {
+	hex := strings.TrimPrefix(digest, "sha256:")
+	if len(hex) < 2 {
+		return filepath.Join(s.dir, "blobs", "sha256", hex)
+	}
+	return filepath.Join(s.dir, "blobs", "sha256", hex[:2], hex)
+}
+
+// Link makes the content stored for url available at name, as a hard link to
+// the blob, or a copy when a link can't be made, like across filesystems.
+// Blobs are shared, so a hard linked file must not be modified in place.
+func (s *Store) Link(url, name string) error {
+	digest, ok := s.Lookup(url)
+	if !ok {
+		return fmt.Errorf("%s: %w", url, os.ErrNotExist)
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	os.Remove(name)
+	if err := os.Link(s.Path(digest), name); err == nil {
+		return nil
+	}
+	return copyFile(s.Path(digest), name)
+}
+
+// Remove drops url from the index. Its blob stays until it's collected by
+// [Store.GC].
+func (s *Store) Remove(url string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.index[url]; !ok {
+		return nil
+	}
+	return s.record(storeChange{URL: url})
+}
+
+// GC removes the blobs which no index entry refers to, and returns their
+// digests and how many bytes were freed. Temporary files left behind by
+// interrupted Puts are removed too, and count towards the bytes freed.
+func (s *Store) GC() ([]string, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	live := map[string]bool{}
+	for _, digest := range s.index {
+		live[digest] = true
+	}
+
+	removed := []string{}
+	freed := int64(0)
+	remove := func(path string, entry fs.DirEntry) error {
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		freed += info.Size()
+		return nil
+	}
+
+	root := filepath.Join(s.dir, "blobs", "sha256")
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		digest := "sha256:" + entry.Name()
+		if live[digest] {
+			return nil
+		}
+		if err := remove(path, entry); err != nil {
+			return err
+		}
+		removed = append(removed, digest)
+		return nil
+	})
+	if err != nil {
+		return removed, freed, err
+	}
+
+	tmp := filepath.Join(s.dir, "tmp")
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		return removed, freed, err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(tmp, entry.Name())
+		if entry.IsDir() || s.pending[path] {
+			continue
+		}
+		if err := remove(path, entry); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, freed, err
+		}
+	}
+	return removed, freed, nil
+}
+
+// record applies a change to the index and appends it to index.log, then
+// compacts the log once it's grown past the index. The caller holds s.mu.
+func (s *Store) record(change storeChange) error {
+	file, err := os.OpenFile(s.logPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := appendJSONLine(file, change); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if change.Digest == "" {
+		delete(s.index, change.URL)
+	} else {
+		s.index[change.URL] = change.Digest
+	}
+	s.logged++
+
+	if s.logged > compactMinRecords && s.logged > len(s.index) {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact writes the whole index to index.json through a temporary file, so a
+// crash leaves either the old or the new index in place, then drops the log.
+// Replaying a log which was already folded in changes nothing, so a crash in
+// between is harmless. The caller holds s.mu.
+func (s *Store) compact() error {
+	data, err := json.MarshalIndent(s.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = replaceFile(s.indexPath(), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(s.logPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	s.logged = 0
+	return nil
+}
+
+func (s *Store) indexPath() string {
+	return filepath.Join(s.dir, "index.json")
+}
+
+func (s *Store) logPath() string {
+	return filepath.Join(s.dir, "index.log")
+}
+
+// DownloadToStore downloads urls into the store, and returns a map of
+// {url:digest}. Bodies are streamed into the store rather than held in memory.
+// Errors are handled according to the ErrorPolicy.
+func (d *Downloader) DownloadToStore(ctx context.Context, s *Store, urls []string) (map[string]string, error) {
+	items := make([]Item, len(urls))
+	for i, url := range urls {
+		items[i] = Item{URL: url}
+	}
+
+	digests := make(map[string]string, len(urls))
+	mu := sync.Mutex{}
+
+	err := d.each(ctx, items, func(ctx context.Context, item Item) error {
+		var digest string
+		err := d.retry(ctx, func() error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, item.URL, nil)
+			if err != nil {
+				return err
+			}
+			res, _, err := d.do(req)
+			if err != nil {
+				return err
+			}
+			defer res.Body.Close()
+			if err := d.checkStatus(item.URL, res.StatusCode); err != nil {
+				return err
+			}
+			var body io.Reader = res.Body
+			var start []byte
+			if d.ContentTypes != nil {
+				if start, body, err = peek(res.Body); err != nil {
+					return err
+				}
+			}
+			if err := d.ContentTypes.check(item.URL, res, start); err != nil {
+				d.Metrics.error(err)
+				return err
+			}
+			digest, err = s.Put(item.URL, body)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		digests[item.URL] = digest
+		mu.Unlock()
+		return nil
+	})
+	return digests, err
+}
@@ -0,0 +1,175 @@
+package concurrentdownloads_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestStore(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("same")) })
+	mux.HandleFunc("/mirror/a", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("same")) })
+	mux.HandleFunc("/b", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("different")) })
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	countBlobs := func(t *testing.T, dir string) int {
+		matches, err := filepath.Glob(filepath.Join(dir, "blobs", "sha256", "*", "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(matches)
+	}
+
+	t.Run("it stores content once", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := OpenStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls := []string{srv.URL + "/a", srv.URL + "/mirror/a", srv.URL + "/b"}
+		digests, err := (&Downloader{}).DownloadToStore(t.Context(), s, urls)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if digests[urls[0]] != digests[urls[1]] || digests[urls[0]] == digests[urls[2]] {
+			t.Errorf("unexpected digests %v", digests)
+		}
+		if n := countBlobs(t, dir); n != 2 {
+			t.Errorf("expected 2 blobs, got %v", n)
+		}
+
+		f, err := s.Open(urls[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if data, _ := io.ReadAll(f); string(data) != "same" {
+			t.Errorf("unexpected content %q", data)
+		}
+	})
+
+	t.Run("it persists the index", func(t *testing.T) {
+		dir := t.TempDir()
+		s, _ := OpenStore(dir)
+		digest, err := s.Put("https://example.com/x", strings.NewReader("x"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s, err = OpenStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := s.Lookup("https://example.com/x"); !ok || got != digest {
+			t.Errorf("expected %v, got %v", digest, got)
+		}
+	})
+
+	t.Run("it links content out of the store", func(t *testing.T) {
+		dir := t.TempDir()
+		s, _ := OpenStore(dir)
+		digest, _ := s.Put("https://example.com/x", strings.NewReader("x"))
+
+		name := filepath.Join(t.TempDir(), "out", "x")
+		if err := s.Link("https://example.com/x", name); err != nil {
+			t.Fatal(err)
+		}
+		linked, _ := os.Stat(name)
+		blob, _ := os.Stat(s.Path(digest))
+		if !os.SameFile(linked, blob) {
+			t.Errorf("expected a hard link to the blob")
+		}
+	})
+
+	t.Run("it collects unreferenced blobs", func(t *testing.T) {
+		dir := t.TempDir()
+		s, _ := OpenStore(dir)
+		kept, _ := s.Put("https://example.com/kept", strings.NewReader("kept"))
+		s.Put("https://example.com/dropped", strings.NewReader("dropped"))
+		s.Put("https://example.com/also-kept", strings.NewReader("kept"))
+		if err := s.Remove("https://example.com/dropped"); err != nil {
+			t.Fatal(err)
+		}
+
+		removed, freed, err := s.GC()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(removed) != 1 || freed != int64(len("dropped")) {
+			t.Errorf("unexpected removal %v, %v bytes", removed, freed)
+		}
+		if _, err := os.Stat(s.Path(kept)); err != nil {
+			t.Errorf("expected the referenced blob to stay: %v", err)
+		}
+		if n := countBlobs(t, dir); n != 1 {
+			t.Errorf("expected 1 blob, got %v", n)
+		}
+	})
+
+	t.Run("it appends changes to the index", func(t *testing.T) {
+		dir := t.TempDir()
+		s, _ := OpenStore(dir)
+		for i := range 100 {
+			if _, err := s.Put(fmt.Sprintf("https://example.com/%d", i), strings.NewReader("x")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s.Remove("https://example.com/0")
+
+		if _, err := os.Stat(filepath.Join(dir, "index.json")); !os.IsNotExist(err) {
+			t.Errorf("expected the index not to be rewritten, got %v", err)
+		}
+		data, _ := os.ReadFile(filepath.Join(dir, "index.log"))
+		if lines := bytes.Count(data, []byte("\n")); lines != 101 {
+			t.Errorf("expected 101 changes in the log, got %v", lines)
+		}
+
+		// Simulate a crash halfway through appending a change
+		f, _ := os.OpenFile(filepath.Join(dir, "index.log"), os.O_WRONLY|os.O_APPEND, 0o644)
+		f.WriteString(`{"url":"https://example.com/torn","dig`)
+		f.Close()
+
+		s, err := OpenStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := s.Lookup("https://example.com/0"); ok {
+			t.Error("expected the removed URL to stay removed")
+		}
+		if _, ok := s.Lookup("https://example.com/99"); !ok {
+			t.Error("expected the last URL to be stored")
+		}
+		if _, err := os.Stat(filepath.Join(dir, "index.log")); !os.IsNotExist(err) {
+			t.Errorf("expected the log to be folded into the index, got %v", err)
+		}
+	})
+
+	t.Run("it collects leftover temporary files", func(t *testing.T) {
+		dir := t.TempDir()
+		s, _ := OpenStore(dir)
+		// An interrupted Put leaves its temporary file behind
+		os.WriteFile(filepath.Join(dir, "tmp", "blob.123"), []byte("partial"), 0o644)
+
+		removed, freed, err := s.GC()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(removed) != 0 || freed != int64(len("partial")) {
+			t.Errorf("unexpected removal %v, %v bytes", removed, freed)
+		}
+		if entries, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(entries) != 0 {
+			t.Errorf("expected tmp to be empty, got %v", entries)
+		}
+	})
+}
@@ -0,0 +1,495 @@
+package concurrentdownloads
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/lipgloss/v2/table"
+)
+
+// syncStateFile is kept in the local directory, and records the ETag of each
+// file as it was downloaded.
+const syncStateFile = ".sync.json"
+
+// RemoteFile is a file in a remote directory listing.
+type RemoteFile struct {
+	// Path is relative to the listing it was found in.
+	Path string
+	URL  string
+	// Size is -1 when it isn't known.
+	Size    int64
+	ModTime time.Time
+	ETag    string
+}
+
+// SyncAction is what a sync does to a file.
+type SyncAction string
+
+const (
+	SyncDownload SyncAction = "download"
+	SyncUpdate   SyncAction = "update"
+	SyncDelete   SyncAction = "delete"
+)
+
+// SyncChange is a change a sync makes, or would make in a dry run.
+type SyncChange struct {
+	Path   string
+	Action SyncAction
+	// Reason explains why a file is updated, like "size changed".
+	Reason string
+	Err    error
+}
+
+// SyncReport lists the changes made by a sync.
+type SyncReport struct {
+	Changes []SyncChange
+	// Unchanged is the number of files which were already up to date.
+	Unchanged int
+	DryRun    bool
+}
+
+// Syncer mirrors a remote directory into a local one, like rsync over HTTP.
+// The remote directory is read from an index, either an HTML autoindex page
+// or a JSON listing in the format of nginx's autoindex_format json, and its
+// subdirectories are followed.
+type Syncer struct {
+	// Downloader provides the worker pool and its concurrency limit, and
+	// its ErrorPolicy decides whether one failed file stops the rest.
+	Downloader *Downloader
+	// Delete removes local files which are no longer listed remotely.
+	Delete bool
+	// DryRun reports what would change without changing anything.
+	DryRun bool
+}
+
+// syncState is what's recorded about each downloaded file.
+type syncState struct {
+	ETag string `json:"etag,omitempty"`
+}
+
+// Sync brings dir up to date with the remote directory at index. Files are
+// downloaded when they're missing locally, or their size, modification time
+// or ETag has changed.
+func (s *Syncer) Sync(ctx context.Context, index, dir string) (*SyncReport, error) {
+	d := s.Downloader
+	if d == nil {
+		d = &Downloader{}
+	}
+
+	remote, err := s.list(ctx, d, index)
+	if err != nil {
+		return nil, err
+	}
+	state, err := loadSyncState(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SyncReport{DryRun: s.DryRun}
+	listed := map[string]bool{}
+	for _, file := range remote {
+		listed[file.Path] = true
+		action, reason, err := compareFile(file, filepath.Join(dir, filepath.FromSlash(file.Path)), state[file.Path])
+		if err != nil {
+			return nil, err
+		}
+		if action == "" {
+			report.Unchanged++
+			continue
+		}
+		report.Changes = append(report.Changes, SyncChange{Path: file.Path, Action: action, Reason: reason})
+	}
+
+	if s.Delete {
+		err := filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) && name == dir {
+				return fs.SkipAll
+			}
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(dir, name)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if rel != syncStateFile && !strings.HasSuffix(rel, ".part") && !listed[rel] {
+				report.Changes = append(report.Changes, SyncChange{Path: rel, Action: SyncDelete, Reason: "removed remotely"})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.DryRun {
+		return report, nil
+	}
+	err = s.apply(ctx, d, dir, remote, state, report)
+	if saveErr := saveSyncState(dir, state); saveErr != nil {
+		err = errors.Join(err, saveErr)
+	}
+	return report, err
+}
+
+// apply downloads and deletes the files in the report, recording the error of
+// each change that fails.
+func (s *Syncer) apply(ctx context.Context, d *Downloader, dir string, remote []RemoteFile, state map[string]syncState, report *SyncReport) error {
+	byPath := map[string]RemoteFile{}
+	for _, file := range remote {
+		byPath[file.Path] = file
+	}
+	files := map[string]RemoteFile{}
+	changes := map[string]*SyncChange{}
+	items := []Item{}
+	for i := range report.Changes {
+		change := &report.Changes[i]
+		if change.Action == SyncDelete {
+			change.Err = os.Remove(filepath.Join(dir, filepath.FromSlash(change.Path)))
+			if change.Err == nil {
+				delete(state, change.Path)
+			}
+			continue
+		}
+		file := byPath[change.Path]
+		files[file.URL] = file
+		changes[file.URL] = change
+		items = append(items, Item{URL: file.URL})
+	}
+
+	mu := sync.Mutex{}
+	err := d.each(ctx, items, func(ctx context.Context, item Item) error {
+		file := files[item.URL]
+		etag, err := d.syncFile(ctx, file, filepath.Join(dir, filepath.FromSlash(file.Path)))
+
+		mu.Lock()
+		defer mu.Unlock()
+		changes[item.URL].Err = err
+		if err == nil {
+			state[file.Path] = syncState{ETag: etag}
+		}
+		return err
+	})
+
+	errs := []error{err}
+	for _, change := range report.Changes {
+		if change.Action == SyncDelete && change.Err != nil {
+			errs = append(errs, change.Err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// syncFile downloads a file through a ".part" file next to it, and gives it
+// the remote modification time. It returns the ETag it was downloaded with.
+func (d *Downloader) syncFile(ctx context.Context, file RemoteFile, name string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return "", err
+	}
+	part := name + ".part"
+	// A part left over from an earlier run may be of an older version
+	os.Remove(part)
+
+	etag := file.ETag
+	err := d.retry(ctx, func() error {
+		p, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+		defer p.Close()
+		return d.downloadPart(ctx, Item{URL: file.URL}, filePartial{p}, etag, func(res *http.Response, offset int64) error {
+			etag = res.Header.Get("ETag")
+			if file.ModTime.IsZero() {
+				file.ModTime, _ = http.ParseTime(res.Header.Get("Last-Modified"))
+			}
+			return nil
+		})
+	})
+	if err == nil && !file.ModTime.IsZero() {
+		err = os.Chtimes(part, time.Time{}, file.ModTime)
+	}
+	if err == nil {
+		err = os.Rename(part, name)
+	}
+	if err != nil {
+		os.Remove(part)
+		return "", err
+	}
+	return etag, nil
+}
+
+// compareFile decides what to do with a remote file, given the local file at
+// name and what was recorded when it was last downloaded.
+func compareFile(file RemoteFile, name string, state syncState) (SyncAction, string, error) {
+	info, err := os.Stat(name)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return SyncDownload, "new", nil
+	case err != nil:
+		return "", "", err
+	}
+
+	switch {
+	case file.ETag != "" && state.ETag != "" && normalizeETag(file.ETag) != normalizeETag(state.ETag):
+		return SyncUpdate, "etag changed", nil
+	case file.Size >= 0 && file.Size != info.Size():
+		return SyncUpdate, "size changed", nil
+	case !file.ModTime.IsZero() && !file.ModTime.Truncate(time.Second).Equal(info.ModTime().Truncate(time.Second)):
+		return SyncUpdate, "modified", nil
+	}
+	return "", "", nil
+}
+
+// normalizeETag strips the weak prefix and quotes from an ETag, since
+// listings don't always quote them the way the header does.
+func normalizeETag(etag string) string {
+	return strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+}
+
+// list reads the listing at index and its subdirectories, and fills in the
+// size, modification time and ETag of files the listings don't describe with
+// a HEAD request.
+func (s *Syncer) list(ctx context.Context, d *Downloader, index string) ([]RemoteFile, error) {
+	root, err := url.Parse(index)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(root.Path, "/") {
+		root.Path += "/"
+	}
+
+	mu := sync.Mutex{}
+	files := []RemoteFile{}
+	errs := []error{}
+	// The directories listed or about to be, by their URL after redirects, so
+	// a symlink or redirect back to a parent doesn't loop forever
+	visited := map[string]bool{root.String(): true}
+	d.walk(ctx, []Item{{URL: root.String()}}, func(item Item, push func(Item)) {
+		result, err := d.fetch(ctx, item.URL, http.Header{"Accept": {"application/json, text/html;q=0.9"}})
+		if err == nil {
+			dir := listingURL(result.Response.Request.URL)
+			mu.Lock()
+			seen := dir != item.URL && visited[dir]
+			visited[dir] = true
+			mu.Unlock()
+			if seen || !strings.HasPrefix(result.Response.Request.URL.Path, root.Path) {
+				return
+			}
+
+			var listed []RemoteFile
+			var dirs []string
+			listed, dirs, err = parseListing(root, result)
+			mu.Lock()
+			for _, dir := range dirs {
+				if !visited[dir] {
+					visited[dir] = true
+					push(Item{URL: dir})
+				}
+			}
+			files = append(files, listed...)
+			mu.Unlock()
+		}
+		if err != nil {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}
+	})
+	if err := errors.Join(append(errs, context.Cause(ctx))...); err != nil {
+		return nil, err
+	}
+
+	unknown := []Item{}
+	for _, file := range files {
+		if file.Size < 0 {
+			unknown = append(unknown, Item{URL: file.URL})
+		}
+	}
+	heads := map[string]*http.Response{}
+	err = d.each(ctx, unknown, func(ctx context.Context, item Item) error {
+		return d.retry(ctx, func() error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, item.URL, nil)
+			if err != nil {
+				return err
+			}
+			res, _, err := d.do(req)
+			if err != nil {
+				return err
+			}
+			res.Body.Close()
+			if err := statusError(item.URL, res.StatusCode); err != nil {
+				return err
+			}
+			mu.Lock()
+			heads[item.URL] = res
+			mu.Unlock()
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, file := range files {
+		if res, ok := heads[file.URL]; ok {
+			files[i].Size = res.ContentLength
+			files[i].ModTime, _ = http.ParseTime(res.Header.Get("Last-Modified"))
+			files[i].ETag = res.Header.Get("ETag")
+		}
+	}
+
+	slices.SortFunc(files, func(a, b RemoteFile) int { return strings.Compare(a.Path, b.Path) })
+	return files, nil
+}
+
+// listingURL returns u as the URL of a directory listing, with a trailing
+// slash.
+func listingURL(u *url.URL) string {
+	dir := *u
+	dir.Fragment = ""
+	if !strings.HasSuffix(dir.Path, "/") {
+		dir.Path += "/"
+		dir.RawPath = ""
+	}
+	return dir.String()
+}
+
+// parseListing returns the files and the URLs of the subdirectories in a
+// listing. Only entries below root are returned, so links back up to parent
+// directories are ignored.
+func parseListing(root *url.URL, result *Result) ([]RemoteFile, []string, error) {
+	base := result.Response.Request.URL
+	if !strings.HasSuffix(base.Path, "/") {
+		b := *base
+		b.Path += "/"
+		base = &b
+	}
+
+	type entry struct {
+		Name  string `json:"name"`
+		Type  string `json:"type"`
+		MTime string `json:"mtime"`
+		Size  *int64 `json:"size"`
+		ETag  string `json:"etag"`
+	}
+	entries := []entry{}
+	if isJSON(result.Response.Header.Get("Content-Type")) {
+		if err := json.Unmarshal(result.Body, &entries); err != nil {
+			return nil, nil, &DecodeError{URL: result.URL, Err: err}
+		}
+	} else {
+		for _, link := range extractLinks(base, result.Body) {
+			u, _ := url.Parse(link)
+			name, ok := strings.CutPrefix(u.Path, base.Path)
+			if !ok || name == "" || u.RawQuery != "" || u.Host != base.Host {
+				continue
+			}
+			// Only direct children, autoindex pages link to each one
+			if trimmed := strings.TrimSuffix(name, "/"); strings.Contains(trimmed, "/") {
+				continue
+			}
+			e := entry{Name: name, Type: "file"}
+			if strings.HasSuffix(name, "/") {
+				e = entry{Name: strings.TrimSuffix(name, "/"), Type: "directory"}
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	files := []RemoteFile{}
+	dirs := []string{}
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if e.Name == "" || e.Name == "." || e.Name == ".." || strings.Contains(e.Name, "/") || seen[e.Name] {
+			continue
+		}
+		seen[e.Name] = true
+		ref := &url.URL{Path: e.Name}
+		if e.Type == "directory" {
+			ref.Path += "/"
+			dirs = append(dirs, base.ResolveReference(ref).String())
+			continue
+		}
+
+		u := base.ResolveReference(ref)
+		rel, ok := strings.CutPrefix(u.Path, root.Path)
+		if !ok || rel == "" || rel == syncStateFile || path.Clean(rel) != rel {
+			continue
+		}
+		file := RemoteFile{Path: rel, URL: u.String(), Size: -1, ETag: e.ETag}
+		if e.Size != nil {
+			file.Size = *e.Size
+		}
+		if e.MTime != "" {
+			file.ModTime, _ = http.ParseTime(e.MTime)
+			if file.ModTime.IsZero() {
+				file.ModTime, _ = time.Parse(time.RFC3339, e.MTime)
+			}
+		}
+		files = append(files, file)
+	}
+	return files, dirs, nil
+}
+
+func loadSyncState(dir string) (map[string]syncState, error) {
+	state := map[string]syncState{}
+	data, err := os.ReadFile(filepath.Join(dir, syncStateFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return state, nil
+	case err != nil:
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid sync state %s: %w", filepath.Join(dir, syncStateFile), err)
+	}
+	return state, nil
+}
+
+func saveSyncState(dir string, state map[string]syncState) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(dir, syncStateFile)
+	if err := os.WriteFile(name+".tmp", append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// FormatSyncReport outputs the changes made by a sync in a nicely formatted
+// table using `lipgloss`.
+func FormatSyncReport(r *SyncReport) string {
+	rows := [][]string{}
+	for _, change := range r.Changes {
+		result := "ok"
+		switch {
+		case r.DryRun:
+			result = "dry run"
+		case change.Err != nil:
+			result = change.Err.Error()
+		}
+		rows = append(rows, []string{string(change.Action), change.Path, change.Reason, result})
+	}
+
+	table := table.New().
+		Headers("Action", "Path", "Reason", "Result").
+		Rows(rows...)
+	return fmt.Sprintf("%s\n%d changed, %d unchanged", table.Render(), len(r.Changes), r.Unchanged)
+}
@@ -0,0 +1,189 @@
+package concurrentdownloads_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestSyncer(t *testing.T) {
+	write := func(t *testing.T, name, content string) {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(t *testing.T, name string) string {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	actions := func(report *SyncReport) string {
+		changes := []string{}
+		for _, change := range report.Changes {
+			changes = append(changes, string(change.Action)+" "+change.Path)
+		}
+		return strings.Join(changes, ", ")
+	}
+
+	t.Run("it mirrors an autoindex page", func(t *testing.T) {
+		remote := t.TempDir()
+		local := t.TempDir()
+		write(t, filepath.Join(remote, "a.txt"), "a")
+		write(t, filepath.Join(remote, "sub", "b.txt"), "b")
+		srv := httptest.NewServer(http.FileServer(http.Dir(remote)))
+		defer srv.Close()
+
+		report, err := (&Syncer{}).Sync(t.Context(), srv.URL, local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actions(report) != "download a.txt, download sub/b.txt" {
+			t.Errorf("unexpected changes %v", actions(report))
+		}
+		if read(t, filepath.Join(local, "sub", "b.txt")) != "b" {
+			t.Errorf("unexpected content")
+		}
+		remoteInfo, _ := os.Stat(filepath.Join(remote, "a.txt"))
+		localInfo, _ := os.Stat(filepath.Join(local, "a.txt"))
+		if !localInfo.ModTime().Equal(remoteInfo.ModTime().Truncate(time.Second)) {
+			t.Errorf("expected the remote mtime, got %v", localInfo.ModTime())
+		}
+
+		report, err = (&Syncer{}).Sync(t.Context(), srv.URL, local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Changes) != 0 || report.Unchanged != 2 {
+			t.Errorf("expected nothing to change, got %v", actions(report))
+		}
+
+		// Change the remote side, and leave an extra file locally
+		write(t, filepath.Join(remote, "a.txt"), "changed")
+		os.RemoveAll(filepath.Join(remote, "sub"))
+		write(t, filepath.Join(local, "extra.txt"), "extra")
+
+		report, err = (&Syncer{Delete: true, DryRun: true}).Sync(t.Context(), srv.URL, local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actions(report) != "update a.txt, delete extra.txt, delete sub/b.txt" {
+			t.Errorf("unexpected changes %v", actions(report))
+		}
+		if read(t, filepath.Join(local, "a.txt")) != "a" {
+			t.Error("expected a dry run to change nothing")
+		}
+		if out := FormatSyncReport(report); !strings.Contains(out, "size changed") || !strings.Contains(out, "dry run") {
+			t.Errorf("unexpected report:\n%s", out)
+		}
+
+		if _, err := (&Syncer{Delete: true}).Sync(t.Context(), srv.URL, local); err != nil {
+			t.Fatal(err)
+		}
+		if read(t, filepath.Join(local, "a.txt")) != "changed" {
+			t.Error("expected a.txt to be updated")
+		}
+		for _, name := range []string{"extra.txt", "sub/b.txt"} {
+			if _, err := os.Stat(filepath.Join(local, name)); !os.IsNotExist(err) {
+				t.Errorf("expected %v to be deleted", name)
+			}
+		}
+	})
+
+	t.Run("it compares ETags from a JSON listing", func(t *testing.T) {
+		etag := `"v1"`
+		mux := http.NewServeMux()
+		mux.HandleFunc("/files/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[
+				{"name": "data.bin", "type": "file", "mtime": "Wed, 01 Jan 2025 00:00:00 GMT", "size": 4, "etag": "` + strings.Trim(etag, `"`) + `"},
+				{"name": "..", "type": "directory"}
+			]`))
+		})
+		mux.HandleFunc("/files/data.bin", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", etag)
+			w.Write([]byte(etag[1:3] + "!!"))
+		})
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+		local := t.TempDir()
+
+		if _, err := (&Syncer{}).Sync(t.Context(), srv.URL+"/files/", local); err != nil {
+			t.Fatal(err)
+		}
+		info, _ := os.Stat(filepath.Join(local, "data.bin"))
+		if !info.ModTime().Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("unexpected mtime %v", info.ModTime())
+		}
+
+		// Listings often leave the quotes off
+		report, err := (&Syncer{}).Sync(t.Context(), srv.URL+"/files/", local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Changes) != 0 {
+			t.Errorf("expected nothing to change, got %+v", report.Changes)
+		}
+
+		// Same size and mtime, so only the ETag gives it away
+		etag = `"v2"`
+		report, err = (&Syncer{}).Sync(t.Context(), srv.URL+"/files/", local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Changes) != 1 || report.Changes[0].Reason != "etag changed" {
+			t.Errorf("unexpected changes %+v", report.Changes)
+		}
+		if read(t, filepath.Join(local, "data.bin")) != "v2!!" {
+			t.Errorf("expected the new version")
+		}
+	})
+	t.Run("it lists each directory once", func(t *testing.T) {
+		listings := atomic.Int64{}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[{"name": "tree", "type": "directory"}, {"name": "outside.txt", "type": "file", "size": 1}]`))
+		})
+		mux.HandleFunc("/tree/", func(w http.ResponseWriter, r *http.Request) {
+			listings.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[
+				{"name": "a.txt", "type": "file", "size": 1},
+				{"name": "self", "type": "directory"},
+				{"name": "up", "type": "directory"}
+			]`))
+		})
+		// Like symlinks to the directory itself and to its parent
+		mux.Handle("/tree/self/", http.RedirectHandler("/tree/", http.StatusFound))
+		mux.Handle("/tree/up/", http.RedirectHandler("/", http.StatusFound))
+		mux.HandleFunc("/tree/a.txt", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("a")) })
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+
+		ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+		defer cancel()
+		report, err := (&Syncer{}).Sync(ctx, srv.URL+"/tree/", t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actions(report) != "download a.txt" {
+			t.Errorf("unexpected changes %v", actions(report))
+		}
+		if n := listings.Load(); n != 2 {
+			t.Errorf("expected the listing and one redirect to it, got %v", n)
+		}
+	})
+}
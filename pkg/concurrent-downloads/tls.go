@@ -0,0 +1,145 @@
+package concurrentdownloads
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// TLSConfig configures how a Downloader connects to HTTPS servers.
+type TLSConfig struct {
+	// CABundles are PEM encoded certificates which are trusted as well as
+	// the system roots.
+	CABundles [][]byte
+	// ClientCerts are presented to servers which ask for one, the first
+	// match for the host wins.
+	ClientCerts []ClientCert
+	// MinVersion is the minimum TLS version, like tls.VersionTLS13. Zero
+	// uses the crypto/tls default.
+	MinVersion uint16
+	// Pins maps a host name to the public keys it may use, as base64
+	// encoded SHA-256 hashes of the SPKI, like HPKP's pin-sha256. A host
+	// with pins must have one of them somewhere in its verified chain.
+	Pins map[string][]string
+}
+
+// ClientCert is a client certificate for mutual TLS.
+type ClientCert struct {
+	// Host is the host name to match. A leading dot matches all of its
+	// subdomains, and "*" matches every host.
+	Host        string
+	Certificate tls.Certificate
+}
+
+// PinError is returned when a server's certificate chain doesn't match any of
+// the pins for its host.
+type PinError struct {
+	Host string
+	// Pins are the SPKI hashes of the chain the server presented.
+	Pins []string
+}
+
+func (e *PinError) Error() string {
+	return fmt.Sprintf("certificate pin mismatch for %s, got %s", e.Host, strings.Join(e.Pins, ", "))
+}
+
+// SPKIPin returns the pin for a certificate's public key, for
+// [TLSConfig.Pins].
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// tlsHostKey is the context key for the host a request is connecting to, so
+// the client certificate can be picked for it.
+type tlsHostKey struct{}
+
+// config builds the crypto/tls config.
+func (c *TLSConfig) config() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: c.MinVersion}
+
+	if len(c.CABundles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for i, bundle := range c.CABundles {
+			if !pool.AppendCertsFromPEM(bundle) {
+				return nil, fmt.Errorf("no certificates in CA bundle %d", i)
+			}
+		}
+		config.RootCAs = pool
+	}
+
+	if len(c.ClientCerts) > 0 {
+		config.GetClientCertificate = c.clientCertificate
+	}
+	if len(c.Pins) > 0 {
+		config.VerifyConnection = c.verifyPins
+	}
+	return config, nil
+}
+
+// clientCertificate picks the certificate for the host in the handshake's
+// context. Connections are pooled by host, so each connection only ever
+// serves the host it was made for.
+func (c *TLSConfig) clientCertificate(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	host, _ := info.Context().Value(tlsHostKey{}).(string)
+	for _, cert := range c.ClientCerts {
+		if matchHost(cert.Host, host) {
+			return &cert.Certificate, nil
+		}
+	}
+	// No certificate, and the server decides whether that's acceptable
+	return &tls.Certificate{}, nil
+}
+
+// verifyPins checks the verified chain against the pins for its host, after
+// the usual certificate verification has passed. IP addresses aren't sent as
+// the server name, so for those the pins of every IP address the certificate
+// is valid for must match.
+func (c *TLSConfig) verifyPins(state tls.ConnectionState) error {
+	hosts := []string{state.ServerName}
+	if state.ServerName == "" && len(state.PeerCertificates) > 0 {
+		hosts = hosts[:0]
+		for _, ip := range state.PeerCertificates[0].IPAddresses {
+			hosts = append(hosts, ip.String())
+		}
+	}
+
+	got := []string{}
+	for _, chain := range state.VerifiedChains {
+		for _, cert := range chain {
+			got = append(got, SPKIPin(cert))
+		}
+	}
+	for _, host := range hosts {
+		pins, ok := c.Pins[host]
+		if ok && !slices.ContainsFunc(got, func(pin string) bool { return slices.Contains(pins, pin) }) {
+			return &PinError{Host: host, Pins: got}
+		}
+	}
+	return nil
+}
+
+// withTLSHost records the host a request connects to in its context.
+func withTLSHost(ctx context.Context, host string) context.Context {
+	return context.WithValue(ctx, tlsHostKey{}, host)
+}
+
+// tlsHostTransport records the host of each request in its context as it's
+// sent. Redirects reuse the first request's context, so recording it once up
+// front would present the first host's certificate to every host after it.
+type tlsHostTransport struct {
+	http.RoundTripper
+}
+
+func (t tlsHostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.RoundTripper.RoundTrip(req.WithContext(withTLSHost(req.Context(), req.URL.Hostname())))
+}
@@ -0,0 +1,152 @@
+package concurrentdownloads_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/elsewhere" {
+			_, port, _ := net.SplitHostPort(r.Host)
+			http.Redirect(w, r, "https://example.com:"+port+"/", http.StatusFound)
+			return
+		}
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.Write([]byte("anonymous"))
+			return
+		}
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	t.Run("it needs the CA", func(t *testing.T) {
+		d := &Downloader{TLS: &TLSConfig{}}
+		if _, err := d.FetchURL(t.Context(), srv.URL); err == nil {
+			t.Error("expected an unknown authority error")
+		}
+	})
+
+	t.Run("it trusts extra CAs", func(t *testing.T) {
+		d := &Downloader{TLS: &TLSConfig{CABundles: [][]byte{ca}}}
+		body, err := d.FetchURL(t.Context(), srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "anonymous" {
+			t.Errorf("unexpected body %q", body)
+		}
+	})
+
+	t.Run("it rejects bad CA bundles", func(t *testing.T) {
+		d := &Downloader{TLS: &TLSConfig{CABundles: [][]byte{[]byte("nope")}}}
+		if _, err := d.FetchURL(t.Context(), srv.URL); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("it presents client certificates by host", func(t *testing.T) {
+		d := &Downloader{TLS: &TLSConfig{
+			CABundles: [][]byte{ca},
+			ClientCerts: []ClientCert{
+				{Host: "example.com", Certificate: clientCert(t, "other")},
+				{Host: "127.0.0.1", Certificate: clientCert(t, "builder")},
+			},
+		}}
+		body, err := d.FetchURL(t.Context(), srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "builder" {
+			t.Errorf("expected the builder certificate, got %q", body)
+		}
+	})
+
+	t.Run("it picks the client certificate again after a redirect", func(t *testing.T) {
+		d := &Downloader{
+			// The test server's certificate is valid for example.com too
+			Resolve: map[string]string{"example.com": "127.0.0.1"},
+			TLS: &TLSConfig{
+				CABundles: [][]byte{ca},
+				ClientCerts: []ClientCert{
+					{Host: "example.com", Certificate: clientCert(t, "other")},
+					{Host: "127.0.0.1", Certificate: clientCert(t, "builder")},
+				},
+			},
+		}
+		body, err := d.FetchURL(t.Context(), srv.URL+"/elsewhere")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "other" {
+			t.Errorf("expected the example.com certificate, got %q", body)
+		}
+	})
+
+	t.Run("it enforces the minimum version", func(t *testing.T) {
+		old := httptest.NewUnstartedServer(srv.Config.Handler)
+		old.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+		old.StartTLS()
+		defer old.Close()
+
+		d := &Downloader{TLS: &TLSConfig{CABundles: [][]byte{ca}, MinVersion: tls.VersionTLS13}}
+		if _, err := d.FetchURL(t.Context(), old.URL); err == nil {
+			t.Error("expected a protocol version error")
+		}
+	})
+
+	t.Run("it pins public keys", func(t *testing.T) {
+		pin := SPKIPin(srv.Certificate())
+		d := &Downloader{TLS: &TLSConfig{CABundles: [][]byte{ca}, Pins: map[string][]string{"127.0.0.1": {pin}}}}
+		if _, err := d.FetchURL(t.Context(), srv.URL); err != nil {
+			t.Fatal(err)
+		}
+
+		d = &Downloader{TLS: &TLSConfig{CABundles: [][]byte{ca}, Pins: map[string][]string{"127.0.0.1": {"bm9wZQ=="}}}}
+		_, err := d.FetchURL(t.Context(), srv.URL)
+		var pinErr *PinError
+		if !errors.As(err, &pinErr) {
+			t.Fatalf("expected a pin error, got %v", err)
+		}
+		if pinErr.Host != "127.0.0.1" || pinErr.Pins[0] != pin {
+			t.Errorf("unexpected pin error %+v", pinErr)
+		}
+	})
+}
+
+// clientCert makes a self-signed client certificate.
+func clientCert(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,186 @@
+package concurrentdownloads
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timing is the breakdown of where the time went in a request. Phases which
+// didn't happen, like dialing on a reused connection, are zero.
+type Timing struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// FirstByte is the time from starting the request until the first byte
+	// of the response arrived.
+	FirstByte time.Duration
+	// Transfer is the time spent reading the response body.
+	Transfer time.Duration
+	Total    time.Duration
+	// Reused is true when the request was sent on a pooled connection.
+	Reused bool
+}
+
+// Span is a single timed phase of a request.
+type Span struct {
+	URL string
+	// Name is one of "dns", "connect", "tls", "wait", "transfer" or
+	// "request", which covers the whole request.
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// Tracer receives the spans of each request once it completes, so they can
+// be forwarded to a tracing system. It's called with the request's context,
+// and may be called concurrently.
+type Tracer interface {
+	Span(ctx context.Context, span Span)
+}
+
+// timing records when each phase of a request happened, from httptrace.
+type timing struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	gotConn      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	done         time.Time
+	reused       bool
+	// sent are the header fields written for the latest request, which
+	// includes the ones the transport adds.
+	sent http.Header
+}
+
+// trace returns the hooks which fill in t. Dialing may race several addresses,
+// so the first start and last successful finish of each phase are kept.
+func (t *timing) trace() *httptrace.ClientTrace {
+	now := func(at *time.Time, first bool) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if !first || at.IsZero() {
+			*at = time.Now()
+		}
+	}
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { now(&t.dnsStart, true) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { now(&t.dnsDone, false) },
+		ConnectStart: func(string, string) {
+			now(&t.connectStart, true)
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				now(&t.connectDone, false)
+			}
+		},
+		TLSHandshakeStart: func() { now(&t.tlsStart, true) },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				now(&t.tlsDone, false)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.gotConn = time.Now()
+			t.reused = info.Reused
+			// Every request of a redirect chain gets a connection first
+			t.sent = nil
+		},
+		WroteHeaderField: func(key string, values []string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if t.sent == nil {
+				t.sent = http.Header{}
+			}
+			// HTTP/2 pseudo-headers, only the authority has an HTTP/1 field
+			switch {
+			case key == ":authority":
+				key = "Host"
+			case strings.HasPrefix(key, ":"):
+				return
+			}
+			key = http.CanonicalHeaderKey(key)
+			t.sent[key] = append(t.sent[key], values...)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			now(&t.wroteRequest, false)
+		},
+		GotFirstResponseByte: func() { now(&t.firstByte, false) },
+	}
+}
+
+// sentHeader returns the header fields written for the latest request.
+func (t *timing) sentHeader() http.Header {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sent.Clone()
+}
+
+// Timing returns the breakdown of the phases recorded so far.
+func (t *timing) Timing() Timing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return Timing{
+		DNS:       between(t.dnsStart, t.dnsDone),
+		Connect:   between(t.connectStart, t.connectDone),
+		TLS:       between(t.tlsStart, t.tlsDone),
+		FirstByte: between(t.start, t.firstByte),
+		Transfer:  between(t.firstByte, t.done),
+		Total:     between(t.start, t.done),
+		Reused:    t.reused,
+	}
+}
+
+// spans returns the phases which happened as spans for a Tracer.
+func (t *timing) spans(url string) []Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	wait := t.gotConn
+	if !t.wroteRequest.IsZero() {
+		wait = t.wroteRequest
+	}
+	spans := []Span{}
+	for _, phase := range []struct {
+		name       string
+		start, end time.Time
+	}{
+		{"dns", t.dnsStart, t.dnsDone},
+		{"connect", t.connectStart, t.connectDone},
+		{"tls", t.tlsStart, t.tlsDone},
+		{"wait", wait, t.firstByte},
+		{"transfer", t.firstByte, t.done},
+		{"request", t.start, t.done},
+	} {
+		if !phase.start.IsZero() && !phase.end.IsZero() {
+			spans = append(spans, Span{URL: url, Name: phase.name, Start: phase.start, End: phase.end})
+		}
+	}
+	return spans
+}
+
+func (t *timing) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done = time.Now()
+}
+
+// between returns the time from start to end, or zero if either is missing.
+func between(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
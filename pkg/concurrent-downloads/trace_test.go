@@ -0,0 +1,105 @@
+package concurrentdownloads_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+// spanRecorder is a Tracer which keeps every span it's given.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+func (r *spanRecorder) Span(ctx context.Context, span Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+func TestTiming(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("first"))
+		w.(http.Flusher).Flush()
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte(" second"))
+	})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	t.Run("it times each phase", func(t *testing.T) {
+		d := &Downloader{}
+		result, err := d.Fetch(t.Context(), srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(result.Body) != "first second" {
+			t.Errorf("unexpected body: %q", result.Body)
+		}
+
+		timing := result.Timing
+		if timing.Connect <= 0 || timing.Reused {
+			t.Errorf("expected a new connection, got %+v", timing)
+		}
+		if timing.FirstByte < 10*time.Millisecond || timing.Transfer < 10*time.Millisecond {
+			t.Errorf("expected the server's delays, got %+v", timing)
+		}
+		if timing.Total < timing.FirstByte+timing.Transfer {
+			t.Errorf("expected total to cover the other phases, got %+v", timing)
+		}
+
+		// The second request to the same host can reuse the connection
+		result, err = d.Fetch(t.Context(), srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Timing.Reused || result.Timing.Connect != 0 {
+			t.Errorf("expected a reused connection, got %+v", result.Timing)
+		}
+	})
+
+	t.Run("it attaches timing to each result", func(t *testing.T) {
+		d := &Downloader{}
+		results, err := d.Results(t.Context(), []Item{{URL: srv.URL + "/a"}, {URL: srv.URL + "/b"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for url, result := range results {
+			if result.Timing.Total < 20*time.Millisecond {
+				t.Errorf("unexpected timing for %v: %+v", url, result.Timing)
+			}
+		}
+	})
+
+	t.Run("it sends spans to the tracer", func(t *testing.T) {
+		// A fresh server, so there's no pooled connection to reuse
+		srv := httptest.NewServer(handler)
+		defer srv.Close()
+
+		tracer := &spanRecorder{}
+		d := &Downloader{Tracer: tracer}
+		if _, err := d.FetchURL(t.Context(), srv.URL); err != nil {
+			t.Fatal(err)
+		}
+
+		names := []string{}
+		for _, span := range tracer.spans {
+			names = append(names, span.Name)
+			if span.URL != srv.URL || span.End.Before(span.Start) {
+				t.Errorf("unexpected span: %+v", span)
+			}
+		}
+		expected := []string{"connect", "wait", "transfer", "request"}
+		if !slices.Equal(names, expected) {
+			t.Errorf("expected %v, got %v", expected, names)
+		}
+	})
+}
@@ -0,0 +1,226 @@
+package concurrentdownloads
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultWARCSize is the size a WARC file can grow to before a new one is
+// started, the limit suggested by the WARC specification.
+const defaultWARCSize = 1 << 30
+
+// WARCOptions configures a [WARCWriter].
+type WARCOptions struct {
+	// Prefix starts the name of each file, defaulting to "downloads".
+	Prefix string
+	// MaxSize is the size in bytes a file can grow to before the next
+	// record starts a new one, defaulting to 1GiB.
+	MaxSize int64
+	// Info holds extra fields for the warcinfo record at the start of each
+	// file, like "operator" or "description".
+	Info map[string]string
+}
+
+// WARCWriter is a [Sink] which archives each download as a request and
+// response record pair in WARC 1.1 files. Every record is gzipped on its own,
+// so a reader can seek straight to any of them, and every file starts with a
+// warcinfo record. It's safe to write to from several goroutines at once.
+//
+// Records are of the final request and response, after any redirects, with
+// the request header fields as they were sent. Bodies are recorded as they
+// were received by the client, after any transparent decompression of a
+// gzipped response.
+type WARCWriter struct {
+	dir     string
+	options WARCOptions
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	serial  int
+	started string
+	files   []string
+}
+
+// NewWARCWriter returns a writer which creates its files in dir.
+func NewWARCWriter(dir string, options WARCOptions) (*WARCWriter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	options.Prefix = cmp.Or(options.Prefix, "downloads")
+	options.MaxSize = cmp.Or(options.MaxSize, defaultWARCSize)
+	return &WARCWriter{
+		dir:     dir,
+		options: options,
+		started: time.Now().UTC().Format("20060102150405"),
+	}, nil
+}
+
+// Write records the request and response of result. Once a file has grown to
+// MaxSize, the pair starts a new one.
+func (w *WARCWriter) Write(result *Result) error {
+	res := result.Response
+	date := time.Now().UTC()
+	responseID := warcRecordID()
+
+	response := bytes.Buffer{}
+	fmt.Fprintf(&response, "%s %s\r\n", res.Proto, res.Status)
+	res.Header.Write(&response)
+	response.WriteString("\r\n")
+	response.Write(result.Body)
+
+	// The final request, after any redirects
+	req := res.Request
+	target := req.URL.String()
+	request := bytes.Buffer{}
+	fmt.Fprintf(&request, "%s %s HTTP/1.1\r\n", req.Method, req.URL.RequestURI())
+	sent := result.RequestHeader.Clone()
+	if sent == nil {
+		sent = req.Header.Clone()
+		sent.Set("Host", cmp.Or(req.Host, req.URL.Host))
+	}
+	fmt.Fprintf(&request, "Host: %s\r\n", sent.Get("Host"))
+	sent.Del("Host")
+	sent.Write(&request)
+	request.WriteString("\r\n")
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// The pair always goes in the same file
+	if w.file == nil || w.size >= w.options.MaxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	err := w.append(warcFields{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", responseID},
+		{"WARC-Date", date.Format(time.RFC3339Nano)},
+		{"WARC-Target-URI", target},
+		{"WARC-Payload-Digest", warcDigest(result.Body)},
+		{"Content-Type", "application/http;msgtype=response"},
+	}, response.Bytes())
+	if err != nil {
+		return err
+	}
+	return w.append(warcFields{
+		{"WARC-Type", "request"},
+		{"WARC-Record-ID", warcRecordID()},
+		{"WARC-Date", date.Format(time.RFC3339Nano)},
+		{"WARC-Target-URI", target},
+		{"WARC-Concurrent-To", responseID},
+		{"Content-Type", "application/http;msgtype=request"},
+	}, request.Bytes())
+}
+
+// Files returns the names of the files written so far.
+func (w *WARCWriter) Files() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return slices.Clone(w.files)
+}
+
+// Close closes the current file.
+func (w *WARCWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// warcFields are the named fields of a record header, in order.
+type warcFields [][2]string
+
+// rotate closes the current file, and starts the next one with a warcinfo
+// record. The caller holds w.mu.
+func (w *WARCWriter) rotate() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.file = nil
+	}
+
+	w.serial++
+	name := filepath.Join(w.dir, fmt.Sprintf("%s-%s-%05d.warc.gz", w.options.Prefix, w.started, w.serial))
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.size = 0
+	w.files = append(w.files, name)
+
+	info := map[string]string{
+		"software":   DefaultUserAgent,
+		"format":     "WARC File Format 1.1",
+		"conformsTo": "http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/",
+	}
+	maps.Copy(info, w.options.Info)
+	block := bytes.Buffer{}
+	for _, key := range slices.Sorted(maps.Keys(info)) {
+		fmt.Fprintf(&block, "%s: %s\r\n", key, info[key])
+	}
+
+	return w.append(warcFields{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", warcRecordID()},
+		{"WARC-Date", time.Now().UTC().Format(time.RFC3339Nano)},
+		{"WARC-Filename", filepath.Base(name)},
+		{"Content-Type", "application/warc-fields"},
+	}, block.Bytes())
+}
+
+// append writes a record to the current file as its own gzip member. The
+// caller holds w.mu.
+func (w *WARCWriter) append(fields warcFields, block []byte) error {
+	record := bytes.Buffer{}
+	gz := gzip.NewWriter(&record)
+	fmt.Fprint(gz, "WARC/1.1\r\n")
+	for _, field := range fields {
+		fmt.Fprintf(gz, "%s: %s\r\n", field[0], field[1])
+	}
+	fmt.Fprintf(gz, "WARC-Block-Digest: %s\r\n", warcDigest(block))
+	fmt.Fprintf(gz, "Content-Length: %d\r\n\r\n", len(block))
+	gz.Write(block)
+	fmt.Fprint(gz, "\r\n\r\n")
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	// A whole record in one write, so a failure never leaves half of one
+	// followed by another
+	n, err := w.file.Write(record.Bytes())
+	w.size += int64(n)
+	return err
+}
+
+// warcDigest returns a digest in the form WARC uses, with a base32 hash.
+func warcDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// warcRecordID returns a new record ID, a random UUID.
+func warcRecordID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
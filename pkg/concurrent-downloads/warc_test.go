@@ -0,0 +1,174 @@
+package concurrentdownloads_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/concurrent-downloads"
+)
+
+func TestWARCWriter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("body of " + r.URL.Path))
+	}))
+	defer srv.Close()
+
+	// readRecords reads each gzip member of a file as a record, and returns
+	// the header fields and block of each
+	readRecords := func(t *testing.T, name string) ([]map[string]string, [][]byte) {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := bytes.NewReader(data)
+		headers := []map[string]string{}
+		blocks := [][]byte{}
+		for r.Len() > 0 {
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gz.Multistream(false)
+			record := bufio.NewReader(gz)
+			if line, _ := record.ReadString('\n'); line != "WARC/1.1\r\n" {
+				t.Fatalf("unexpected version line %q", line)
+			}
+			header := map[string]string{}
+			for {
+				line, _ := record.ReadString('\n')
+				if line == "\r\n" || line == "" {
+					break
+				}
+				key, value, _ := strings.Cut(strings.TrimSpace(line), ": ")
+				header[key] = value
+			}
+			n, _ := strconv.Atoi(header["Content-Length"])
+			block := make([]byte, n)
+			io.ReadFull(record, block)
+			if rest, _ := io.ReadAll(record); string(rest) != "\r\n\r\n" {
+				t.Fatalf("unexpected record end %q", rest)
+			}
+			headers = append(headers, header)
+			blocks = append(blocks, block)
+		}
+		return headers, blocks
+	}
+
+	t.Run("it records requests and responses", func(t *testing.T) {
+		dir := t.TempDir()
+		w, err := NewWARCWriter(dir, WARCOptions{Info: map[string]string{"operator": "builds"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		items := []Item{{URL: srv.URL + "/a"}}
+		if err := (&Downloader{}).DownloadTo(t.Context(), w, items); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		files := w.Files()
+		if len(files) != 1 || !strings.HasSuffix(files[0], "-00001.warc.gz") {
+			t.Fatalf("unexpected files %v", files)
+		}
+		headers, blocks := readRecords(t, files[0])
+		types := []string{}
+		for _, header := range headers {
+			types = append(types, header["WARC-Type"])
+		}
+		if strings.Join(types, " ") != "warcinfo response request" {
+			t.Fatalf("unexpected records %v", types)
+		}
+
+		if !bytes.Contains(blocks[0], []byte("operator: builds\r\n")) {
+			t.Errorf("unexpected warcinfo %q", blocks[0])
+		}
+		response := string(blocks[1])
+		if !strings.HasPrefix(response, "HTTP/1.1 200 OK\r\n") || !strings.Contains(response, "Content-Type: text/plain\r\n") || !strings.HasSuffix(response, "\r\n\r\nbody of /a") {
+			t.Errorf("unexpected response %q", response)
+		}
+		if headers[1]["WARC-Target-URI"] != srv.URL+"/a" || !strings.HasPrefix(headers[1]["WARC-Payload-Digest"], "sha256:") {
+			t.Errorf("unexpected response header %v", headers[1])
+		}
+		if !strings.HasPrefix(string(blocks[2]), "GET /a HTTP/1.1\r\nHost: ") {
+			t.Errorf("unexpected request %q", blocks[2])
+		}
+		if headers[2]["WARC-Concurrent-To"] != headers[1]["WARC-Record-ID"] {
+			t.Errorf("expected the request to point at its response")
+		}
+	})
+
+	t.Run("it records the final request as it was sent", func(t *testing.T) {
+		w, err := NewWARCWriter(t.TempDir(), WARCOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		items := []Item{{URL: srv.URL + "/old"}}
+		if err := (&Downloader{}).DownloadTo(t.Context(), w, items); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+
+		headers, blocks := readRecords(t, w.Files()[0])
+		for _, header := range headers[1:] {
+			if header["WARC-Target-URI"] != srv.URL+"/new" {
+				t.Errorf("expected the redirect target, got %v", header)
+			}
+		}
+		request := string(blocks[2])
+		host := strings.TrimPrefix(srv.URL, "http://")
+		for _, s := range []string{"GET /new HTTP/1.1\r\nHost: " + host + "\r\n", "\r\nUser-Agent: ", "Accept-Encoding: gzip\r\n"} {
+			if !strings.Contains(request, s) {
+				t.Errorf("expected %q in request %q", s, request)
+			}
+		}
+	})
+
+	t.Run("it rotates files", func(t *testing.T) {
+		dir := t.TempDir()
+		w, err := NewWARCWriter(dir, WARCOptions{Prefix: "small", MaxSize: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Writers share the sink safely
+		wg := sync.WaitGroup{}
+		for i := range 4 {
+			wg.Go(func() {
+				items := []Item{{URL: srv.URL + "/" + strconv.Itoa(i)}}
+				if err := (&Downloader{}).DownloadTo(t.Context(), w, items); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+		wg.Wait()
+		w.Close()
+
+		// Every pair fills a file, so each one starts a new file
+		files := w.Files()
+		if len(files) != 4 {
+			t.Fatalf("expected 4 files, got %v", len(files))
+		}
+		for _, name := range files {
+			headers, _ := readRecords(t, name)
+			if headers[0]["WARC-Type"] != "warcinfo" || len(headers) != 3 {
+				t.Errorf("unexpected records in %v: %v", name, headers)
+			}
+		}
+	})
+}
@@ -22,7 +22,10 @@ Web server log sample:
 Take a log and output a table representing the number of occurrences of events in the
 log.
 
-Where the event is defined as (Method + Endpoint + HttpStatusCode).
+Where the event is defined as (Method + Endpoint + HttpStatusCode). The package
+functions count each endpoint exactly as it appears in the log. A Parser with a
+Normalizer counts endpoints which only differ by IDs and the like as one, so
+with the DefaultNormalizer the sample above becomes:
 
 Order by Count, descending:
 
@@ -42,20 +45,37 @@ Method |             Endpoint | Code || Count
 package log_parse
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"maps"
+	"math"
+	"os"
 	"regexp"
+	"runtime"
 	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/charmbracelet/lipgloss/v2/table"
 )
 
 var ErrInvalidLogFormat = errors.New("invalid log format")
 
+// batchSize is how many lines ParseReaderParallel hands a worker at once.
+const batchSize = 1024
+
+// maxLineSize is the longest line, with its ending, that ParseReader holds in
+// memory. Longer lines fail with [bufio.ErrTooLong], like a [bufio.Scanner]'s.
+const maxLineSize = 64 * 1024
+
+// lineRe matches a log line, see ParseLine.
+var lineRe = regexp.MustCompile(`\[[^[]+\] ([A-Z]+) (.+) HTTP/1.1 (\d+) (.+)`)
+
 type Event struct {
 	Method     string
 	Endpoint   string
@@ -74,11 +94,7 @@ type EventCount struct {
 //	[02/Nov/2018:21:46:43 +0000] GET /prices/20180103/geo/12 HTTP/1.1 200 iphone-5
 //	[DD/mmm/YYYY:HH:MM:SS +0000] METHOD URI HTTP/1.1 STATUS USERAGENT
 func ParseLine(record string) (Event, error) {
-	pattern := `\[[^[]+\] ([A-Z]+) (.+) HTTP/1.1 (\d+) (.+)`
-	// Build a regex
-	re := regexp.MustCompile(pattern)
-
-	match := re.FindStringSubmatch(record)
+	match := lineRe.FindStringSubmatch(record)
 	if match != nil {
 		// timestamp := match[0]
 		method := match[1]
@@ -99,30 +115,206 @@ func ParseLine(record string) (Event, error) {
 
 // CountEvents counts the number of events in a list of logs.
 func CountEvents(logs []string) []*EventCount {
-	events := make(map[Event]*EventCount)
+	return (&Parser{}).CountEvents(logs)
+}
+
+// ParseLog parses a text containing log lines and returns the count of events.
+func ParseLog(text string) []*EventCount {
+	return (&Parser{}).ParseLog(text)
+}
+
+// ParseReader parses log lines as they're read from r, and returns the count
+// of events. Only one line is held in memory at a time, so it works for logs
+// of any size. Lines can end with LF or CRLF, and the last one doesn't need an
+// ending. Lines longer than 64 KiB fail with [bufio.ErrTooLong].
+func ParseReader(r io.Reader) ([]*EventCount, error) {
+	return (&Parser{}).ParseReader(r)
+}
+
+// ParseReaderParallel is like ParseReader, but fans the lines out to workers
+// which parse and count them in parallel. Zero workers uses one per CPU.
+func ParseReaderParallel(r io.Reader, workers int) ([]*EventCount, error) {
+	return (&Parser{}).ParseReaderParallel(r, workers)
+}
+
+// ParseFile parses the log file name with workers which each count the lines
+// in their own byte range of the file, and returns the count of events. Zero
+// workers uses one per CPU. The counts are the same as ParseReader's.
+func ParseFile(name string, workers int) ([]*EventCount, error) {
+	return (&Parser{}).ParseFile(name, workers)
+}
+
+// Parser parses logs into events, and can normalize their endpoints so that
+// requests for the same route are counted as one event.
+//
+// The zero value keeps endpoints as they are, and behaves like the package
+// functions.
+type Parser struct {
+	// Normalizer rewrites each event's endpoint, like [DefaultNormalizer].
+	Normalizer *Normalizer
+}
+
+// ParseLine is like the package ParseLine, but normalizes the endpoint.
+func (p *Parser) ParseLine(record string) (Event, error) {
+	event, err := ParseLine(record)
+	if err != nil {
+		return event, err
+	}
+	event.Endpoint = p.Normalizer.Normalize(event.Endpoint)
+	return event, nil
+}
+
+// CountEvents counts the number of events in a list of logs.
+func (p *Parser) CountEvents(logs []string) []*EventCount {
+	events := p.counter()
 	for _, log := range logs {
-		event, err := ParseLine(log)
-		if err != nil {
-			continue
+		events.add(log)
+	}
+	return events.sorted()
+}
+
+// ParseLog parses a text containing log lines and returns the count of events.
+func (p *Parser) ParseLog(text string) []*EventCount {
+	lines := strings.Split(text, "\n")
+	return p.CountEvents(lines)
+}
+
+// ParseReader is like the package ParseReader.
+func (p *Parser) ParseReader(r io.Reader) ([]*EventCount, error) {
+	events := p.counter()
+	_, err := readLines(bufio.NewReaderSize(r, maxLineSize), math.MaxInt64, events.addBytes)
+	if err != nil {
+		return nil, err
+	}
+	return events.sorted(), nil
+}
+
+// ParseReaderParallel is like the package ParseReaderParallel.
+func (p *Parser) ParseReaderParallel(r io.Reader, workers int) ([]*EventCount, error) {
+	workers = shards(workers)
+	partials := make([]*counter, workers)
+	batches := make(chan []string, workers)
+	wg := sync.WaitGroup{}
+	for i := range partials {
+		partials[i] = p.counter()
+		wg.Go(func() {
+			for batch := range batches {
+				for _, line := range batch {
+					partials[i].add(line)
+				}
+			}
+		})
+	}
+
+	// Lines are sent in batches, so the channel isn't the bottleneck
+	batch := make([]string, 0, batchSize)
+	_, err := readLines(bufio.NewReaderSize(r, maxLineSize), math.MaxInt64, func(line []byte) {
+		batch = append(batch, string(line))
+		if len(batch) == batchSize {
+			batches <- batch
+			batch = make([]string, 0, batchSize)
 		}
-		if _, ok := events[event]; !ok {
-			events[event] = &EventCount{
-				Event: event,
-				Count: 0,
+	})
+	if len(batch) > 0 {
+		batches <- batch
+	}
+	close(batches)
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return merge(partials).sorted(), nil
+}
+
+// ParseFile is like the package ParseFile.
+func (p *Parser) ParseFile(name string, workers int) ([]*EventCount, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+
+	workers = shards(workers)
+	partials := make([]*counter, workers)
+	errs := make([]error, workers)
+	wg := sync.WaitGroup{}
+	for i := range partials {
+		start := size * int64(i) / int64(workers)
+		end := size * int64(i+1) / int64(workers)
+		partials[i] = p.counter()
+		wg.Go(func() {
+			errs[i] = partials[i].readRange(f, start, end, size)
+		})
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return merge(partials).sorted(), nil
+}
+
+// counter counts events as they're parsed.
+type counter struct {
+	parser *Parser
+	events map[Event]*EventCount
+}
+
+func (p *Parser) counter() *counter {
+	return &counter{parser: p, events: make(map[Event]*EventCount)}
+}
+
+// add counts the event in a log line, skipping invalid lines.
+func (c *counter) add(log string) {
+	event, err := c.parser.ParseLine(log)
+	if err != nil {
+		return
+	}
+	if _, ok := c.events[event]; !ok {
+		c.events[event] = &EventCount{
+			Event: event,
+			Count: 0,
+		}
+	}
+	c.events[event].Count++
+}
+
+// addBytes is add for a line that's still in a buffer.
+func (c *counter) addBytes(log []byte) {
+	c.add(string(log))
+}
+
+// merge returns the partial counts added together.
+func merge(partials []*counter) *counter {
+	total := partials[0]
+	for _, partial := range partials[1:] {
+		for event, count := range partial.events {
+			if ev, ok := total.events[event]; ok {
+				ev.Count += count.Count
+			} else {
+				total.events[event] = count
 			}
 		}
-		ev := events[event]
-		ev.Count++
-		events[event] = ev
 	}
+	return total
+}
 
+// sorted returns the counts, ordered by count, descending.
+func (c *counter) sorted() []*EventCount {
 	// Get all the eventcounts and sort them
-	counts := slices.Collect(maps.Values(events))
+	counts := slices.Collect(maps.Values(c.events))
 	sort.Slice(counts, func(i, j int) bool {
 		// Implementing deterministic sorting, not really necessary but improves
 		// testability
 		if counts[i].Count == counts[j].Count {
 			if counts[i].Event.Method == counts[j].Event.Method {
+				if counts[i].Event.Endpoint == counts[j].Event.Endpoint {
+					return counts[i].Event.HttpStatus < counts[j].Event.HttpStatus
+				}
 				return counts[i].Event.Endpoint < counts[j].Event.Endpoint
 			}
 			return counts[i].Event.Method < counts[j].Event.Method
@@ -133,10 +325,62 @@ func CountEvents(logs []string) []*EventCount {
 	return counts
 }
 
-// ParseLog parses a text containing log lines and returns the count of events.
-func ParseLog(text string) []*EventCount {
-	lines := strings.Split(text, "\n")
-	return CountEvents(lines)
+// shards returns the number of workers to use when asked for n.
+func shards(n int) int {
+	if n < 1 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return n
+}
+
+// readRange counts the lines of r which start from start up to end, where r
+// is size bytes long. A line which starts in the range is read to its end,
+// even past the end of the range.
+func (c *counter) readRange(r io.ReaderAt, start, end, size int64) error {
+	br := bufio.NewReaderSize(io.NewSectionReader(r, start, size-start), maxLineSize)
+	if start > 0 {
+		// Unless the range starts right after a newline, its first line
+		// started in an earlier range, which counts it
+		prev := make([]byte, 1)
+		if _, err := r.ReadAt(prev, start-1); err != nil {
+			return err
+		}
+		if prev[0] != '\n' {
+			n, err := readLines(br, 1, func([]byte) {})
+			if err != nil {
+				return err
+			}
+			start += n
+		}
+	}
+	_, err := readLines(br, end-start, c.addBytes)
+	return err
+}
+
+// readLines calls fn with each line read from br, without its line ending,
+// until it reaches EOF or has read limit bytes. A line too long for br's buffer
+// fails with bufio.ErrTooLong. It returns the number of bytes read.
+func readLines(br *bufio.Reader, limit int64, fn func(line []byte)) (int64, error) {
+	n := int64(0)
+	for n < limit {
+		line, err := br.ReadSlice('\n')
+		n += int64(len(line))
+		if err == bufio.ErrBufferFull {
+			return n, bufio.ErrTooLong
+		}
+		if len(line) > 0 {
+			line = bytes.TrimSuffix(line, []byte("\n"))
+			line = bytes.TrimSuffix(line, []byte("\r"))
+			fn(line)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
 }
 
 // Output the counts of events in a nicely formatted table using `lipgloss`
@@ -1,10 +1,19 @@
 package log_parse_test
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	. "github.com/shakefu/go-concurrency-experiments/pkg/log-parse"
 )
@@ -223,3 +232,160 @@ func TestFormatCounts(t *testing.T) {
 		t.Errorf("Expected %v, got %v", expected, actual)
 	}
 }
+
+func TestParseReader(t *testing.T) {
+	log := strings.Join([]string{
+		"[02/Nov/2018:21:46:31 +0000] PUT /users/12345/locations HTTP/1.1 204 iphone-3",
+		"[02/Nov/2018:21:46:34 +0000] POST /rides HTTP/1.1 202 iphone-2",
+		"not a log line",
+		"",
+		"[02/Nov/2018:21:46:35 +0000] POST /rides HTTP/1.1 202 iphone-5",
+	}, "\n")
+
+	t.Run("it counts the same events as ParseLog", func(t *testing.T) {
+		actual, err := ParseReader(strings.NewReader(log))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := ParseLog(log); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected %v, got %v", expected, actual)
+		}
+	})
+
+	t.Run("it handles CRLF line endings", func(t *testing.T) {
+		actual, err := ParseReader(strings.NewReader(strings.ReplaceAll(log, "\n", "\r\n") + "\r\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := ParseLog(log); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected %v, got %v", expected, actual)
+		}
+	})
+
+	t.Run("it fails on lines longer than 64 KiB", func(t *testing.T) {
+		long := "[02/Nov/2018:21:46:36 +0000] GET /rides HTTP/1.1 200 " + strings.Repeat("x", 1<<20)
+		if _, err := ParseReader(strings.NewReader(log + "\n" + long)); !errors.Is(err, bufio.ErrTooLong) {
+			t.Errorf("Expected %v, got %v", bufio.ErrTooLong, err)
+		}
+		if _, err := ParseFile(writeLog(t, log+"\n"+long), 4); !errors.Is(err, bufio.ErrTooLong) {
+			t.Errorf("Expected %v from ParseFile, got %v", bufio.ErrTooLong, err)
+		}
+	})
+
+	t.Run("it returns read errors", func(t *testing.T) {
+		r := io.MultiReader(strings.NewReader(log), iotest.ErrReader(io.ErrUnexpectedEOF))
+		if _, err := ParseReader(r); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Expected %v, got %v", io.ErrUnexpectedEOF, err)
+		}
+	})
+}
+
+// generateLog returns a log of n lines, with a few invalid and CRLF lines
+// mixed in, and lines close to ParseReader's limit when long is set.
+func generateLog(n int, long bool) string {
+	methods := []string{"GET", "PUT", "POST"}
+	statuses := []int{200, 204, 404, 500}
+	b := strings.Builder{}
+	for i := range n {
+		switch {
+		case i%97 == 0:
+			b.WriteString("not a log line\n")
+		case long && i%89 == 0:
+			fmt.Fprintf(&b, "[02/Nov/2018:21:46:31 +0000] GET /long/%d HTTP/1.1 200 %s\n", i%3, strings.Repeat("x", 60_000))
+		default:
+			ending := "\n"
+			if i%7 == 0 {
+				ending = "\r\n"
+			}
+			fmt.Fprintf(&b, "[02/Nov/2018:21:46:31 +0000] %s /users/%d/locations HTTP/1.1 %d iphone-%d%s",
+				methods[i%len(methods)], i%50, statuses[i%len(statuses)], i%5, ending)
+		}
+	}
+	// The last line has no ending
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func writeLog(t testing.TB, log string) string {
+	name := filepath.Join(t.TempDir(), "access.log")
+	if err := os.WriteFile(name, []byte(log), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestParseParallel(t *testing.T) {
+	log := generateLog(1000, true)
+	expected := CountEvents(strings.Split(log, "\n"))
+	name := writeLog(t, log)
+
+	for _, workers := range []int{0, 1, 2, 3, 8, 64} {
+		t.Run(fmt.Sprintf("it counts the same events as CountEvents with %d workers", workers), func(t *testing.T) {
+			actual, err := ParseFile(name, workers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("ParseFile: expected %d events, got %d", len(expected), len(actual))
+			}
+
+			actual, err = ParseReaderParallel(strings.NewReader(log), workers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("ParseReaderParallel: expected %d events, got %d", len(expected), len(actual))
+			}
+		})
+	}
+
+	t.Run("it splits files with fewer bytes than workers", func(t *testing.T) {
+		line := "[02/Nov/2018:21:46:42 +0000] GET /users/9933 HTTP/1.1 200 iphone-5"
+		actual, err := ParseFile(writeLog(t, line+"\n"+line), 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(actual) != 1 || actual[0].Count != 2 {
+			t.Errorf("Expected one event counted twice, got %v", actual)
+		}
+	})
+
+	t.Run("it returns an error for missing files", func(t *testing.T) {
+		if _, err := ParseFile(filepath.Join(t.TempDir(), "missing.log"), 2); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected %v, got %v", os.ErrNotExist, err)
+		}
+	})
+}
+
+func BenchmarkParse(b *testing.B) {
+	log := generateLog(100_000, false)
+	name := writeLog(b, log)
+
+	b.Run("ParseReader", func(b *testing.B) {
+		b.SetBytes(int64(len(log)))
+		for b.Loop() {
+			if _, err := ParseReader(strings.NewReader(log)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	counts := []int{1, 2, 4, runtime.GOMAXPROCS(0)}
+	slices.Sort(counts)
+	for _, workers := range slices.Compact(counts) {
+		b.Run(fmt.Sprintf("ParseFile/workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(log)))
+			for b.Loop() {
+				if _, err := ParseFile(name, workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("ParseReaderParallel/workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(log)))
+			for b.Loop() {
+				if _, err := ParseReaderParallel(strings.NewReader(log), workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
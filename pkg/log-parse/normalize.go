@@ -0,0 +1,95 @@
+package log_parse
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Placeholder replaces the variable segments of an endpoint with the built in
+// rules.
+const Placeholder = "#"
+
+// SegmentRule replaces a whole path segment, like the ID in /users/12345.
+type SegmentRule struct {
+	// Match reports whether the rule applies to a segment.
+	Match func(segment string) bool
+	// Replace is what a matching segment becomes.
+	Replace string
+}
+
+// PatternRule replaces every match of a regular expression anywhere in an
+// endpoint. Replace can refer to submatches, like "$1", see
+// [regexp.Regexp.ReplaceAllString].
+type PatternRule struct {
+	Pattern *regexp.Regexp
+	Replace string
+}
+
+// SegmentPattern returns a rule for segments which match pattern as a whole.
+// It panics if the pattern doesn't compile, like [regexp.MustCompile].
+func SegmentPattern(pattern, replace string) SegmentRule {
+	re := regexp.MustCompile(`^(?:` + pattern + `)$`)
+	return SegmentRule{Match: re.MatchString, Replace: replace}
+}
+
+var (
+	// NumericIDs replaces segments made of digits, like 12345.
+	NumericIDs = SegmentPattern(`[0-9]+`, Placeholder)
+	// UUIDs replaces segments like 123e4567-e89b-12d3-a456-426614174000.
+	UUIDs = SegmentPattern(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`, Placeholder)
+	// HexHashes replaces segments of at least 16 hex digits, which covers
+	// hashes like MD5 and SHA-1, and object IDs, without catching words.
+	HexHashes = SegmentPattern(`[0-9a-fA-F]{16,}`, Placeholder)
+	// Dates replaces segments like 2018-11-02. Compact dates like 20181102
+	// are already covered by NumericIDs.
+	Dates = SegmentPattern(`[0-9]{4}-[0-9]{2}-[0-9]{2}`, Placeholder)
+)
+
+// DefaultNormalizer replaces numeric IDs, UUIDs, hex hashes and dates, so
+// /users/12345/locations becomes /users/#/locations.
+var DefaultNormalizer = &Normalizer{
+	Segments: []SegmentRule{NumericIDs, UUIDs, HexHashes, Dates},
+}
+
+// Normalizer rewrites endpoints, so that requests for the same route are
+// counted as one event.
+type Normalizer struct {
+	// Patterns are applied to the whole endpoint first, in order.
+	Patterns []PatternRule
+	// Segments are then tried on each segment of the path, and the first
+	// rule that matches replaces it. The query string is left alone.
+	Segments []SegmentRule
+}
+
+// Normalize returns the normalized endpoint. A nil *Normalizer returns it
+// unchanged.
+func (n *Normalizer) Normalize(endpoint string) string {
+	if n == nil {
+		return endpoint
+	}
+	for _, rule := range n.Patterns {
+		endpoint = rule.Pattern.ReplaceAllString(endpoint, rule.Replace)
+	}
+	if len(n.Segments) == 0 {
+		return endpoint
+	}
+
+	path, query, hasQuery := strings.Cut(endpoint, "?")
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		for _, rule := range n.Segments {
+			if rule.Match(segment) {
+				segments[i] = rule.Replace
+				break
+			}
+		}
+	}
+	endpoint = strings.Join(segments, "/")
+	if hasQuery {
+		endpoint += "?" + query
+	}
+	return endpoint
+}
@@ -0,0 +1,123 @@
+package log_parse_test
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	. "github.com/shakefu/go-concurrency-experiments/pkg/log-parse"
+)
+
+func TestNormalizer(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		expected string
+	}{
+		{"/users/12345/locations", "/users/#/locations"},
+		{"/prices/20180103/geo/12", "/prices/#/geo/#"},
+		{"/orders/123e4567-e89b-12d3-a456-426614174000", "/orders/#"},
+		{"/blobs/d41d8cd98f00b204e9800998ecf8427e/raw", "/blobs/#/raw"},
+		{"/reports/2018-11-02/", "/reports/#/"},
+		{"/users/994/ride/16?fields=id", "/users/#/ride/#?fields=id"},
+		{"/rides", "/rides"},
+		{"/", "/"},
+		{"/v2/deadbeef", "/v2/deadbeef"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("it normalizes %s", test.endpoint), func(t *testing.T) {
+			if actual := DefaultNormalizer.Normalize(test.endpoint); actual != test.expected {
+				t.Errorf("Expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+
+	t.Run("it leaves endpoints alone without rules", func(t *testing.T) {
+		var n *Normalizer
+		if actual := n.Normalize("/users/12345"); actual != "/users/12345" {
+			t.Errorf("Expected /users/12345, got %s", actual)
+		}
+	})
+
+	t.Run("it applies custom rules", func(t *testing.T) {
+		n := &Normalizer{
+			Patterns: []PatternRule{
+				{Pattern: regexp.MustCompile(`^/static/.*`), Replace: "/static/*"},
+			},
+			Segments: []SegmentRule{
+				SegmentPattern(`@[a-z]+`, ":handle"),
+				NumericIDs,
+			},
+		}
+		tests := map[string]string{
+			"/static/css/site.css":   "/static/*",
+			"/profiles/@shakefu/42":  "/profiles/:handle/#",
+			"/profiles/shakefu/feed": "/profiles/shakefu/feed",
+		}
+		for endpoint, expected := range tests {
+			if actual := n.Normalize(endpoint); actual != expected {
+				t.Errorf("Expected %s, got %s", expected, actual)
+			}
+		}
+	})
+}
+
+func TestParserNormalizes(t *testing.T) {
+	log := `[02/Nov/2018:21:46:31 +0000] PUT /users/12345/locations HTTP/1.1 204 iphone-3
+[02/Nov/2018:21:46:31 +0000] PUT /users/6098/locations HTTP/1.1 204 iphone-3
+[02/Nov/2018:21:46:32 +0000] PUT /users/3911/locations HTTP/1.1 204 moto-x
+[02/Nov/2018:21:46:33 +0000] PUT /users/9933/locations HTTP/1.1 404 moto-x
+[02/Nov/2018:21:46:33 +0000] PUT /users/3911/locations HTTP/1.1 500 moto-x
+[02/Nov/2018:21:46:34 +0000] GET /rides/9943222/status HTTP/1.1 200 moto-x
+[02/Nov/2018:21:46:34 +0000] POST /rides HTTP/1.1 202 iphone-2
+[02/Nov/2018:21:46:35 +0000] POST /users HTTP/1.1 202 iphone-5
+[02/Nov/2018:21:46:35 +0000] POST /rides HTTP/1.1 202 iphone-5
+[02/Nov/2018:21:46:37 +0000] POST /rides HTTP/1.1 202 iphone-4
+[02/Nov/2018:21:46:38 +0000] GET /users/994/ride/16 HTTP/1.1 200 iphone-5
+[02/Nov/2018:21:46:39 +0000] POST /users HTTP/1.1 202 iphone-3
+[02/Nov/2018:21:46:40 +0000] PUT /users/8384721/locations HTTP/1.1 204 iphone-3
+[02/Nov/2018:21:46:41 +0000] GET /users/342111 HTTP/1.1 200 iphone-5
+[02/Nov/2018:21:46:42 +0000] GET /users/9933 HTTP/1.1 200 iphone-5
+[02/Nov/2018:21:46:43 +0000] GET /prices/20180103/geo/12 HTTP/1.1 200 iphone-5`
+
+	// The table from the package documentation
+	expected := []*EventCount{
+		{Event{Method: "PUT", Endpoint: "/users/#/locations", HttpStatus: 204}, 4},
+		{Event{Method: "POST", Endpoint: "/rides", HttpStatus: 202}, 3},
+		{Event{Method: "GET", Endpoint: "/users/#", HttpStatus: 200}, 2},
+		{Event{Method: "POST", Endpoint: "/users", HttpStatus: 202}, 2},
+		{Event{Method: "GET", Endpoint: "/prices/#/geo/#", HttpStatus: 200}, 1},
+		{Event{Method: "GET", Endpoint: "/rides/#/status", HttpStatus: 200}, 1},
+		{Event{Method: "GET", Endpoint: "/users/#/ride/#", HttpStatus: 200}, 1},
+		{Event{Method: "PUT", Endpoint: "/users/#/locations", HttpStatus: 404}, 1},
+		{Event{Method: "PUT", Endpoint: "/users/#/locations", HttpStatus: 500}, 1},
+	}
+	p := &Parser{Normalizer: DefaultNormalizer}
+
+	t.Run("it counts normalized events", func(t *testing.T) {
+		if actual := p.CountEvents(strings.Split(log, "\n")); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected %v, got %v", expected, actual)
+		}
+	})
+
+	t.Run("it counts normalized events from a reader", func(t *testing.T) {
+		actual, err := p.ParseReaderParallel(strings.NewReader(log), 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected %v, got %v", expected, actual)
+		}
+	})
+
+	t.Run("it normalizes parsed lines", func(t *testing.T) {
+		event, err := p.ParseLine("[02/Nov/2018:21:46:42 +0000] GET /users/9933 HTTP/1.1 200 iphone-5")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Endpoint != "/users/#" {
+			t.Errorf("Expected /users/#, got %s", event.Endpoint)
+		}
+	})
+}